@@ -10,6 +10,7 @@ var ANSI = ansi{
 	eot: byte(4),
 	enq: byte(5),
 	ack: byte(6),
+	bel: byte(7),
 	bs:  byte(8),
 	tab: byte(9),
 	lf:  byte(10),
@@ -17,7 +18,13 @@ var ANSI = ansi{
 	cr:  byte(13),
 	so:  byte(14),
 	dle: byte(16),
+	dc3: byte(19),
+	nak: byte(21),
+	can: byte(24),
+	etb: byte(23),
+	em:  byte(25),
 	esc: byte(27),
+	us:  byte(31),
 	del: byte(127),
 
 	escSequenceStart: []byte{byte(27), byte('[')},
@@ -25,6 +32,10 @@ var ANSI = ansi{
 	clearLine:        []byte{byte(27), byte('['), byte('2'), byte('K')},
 	hideCursor:       []byte{byte(27), byte('['), byte('?'), byte('2'), byte('5'), byte('l')},
 	showCursor:       []byte{byte(27), byte('['), byte('?'), byte('2'), byte('5'), byte('h')},
+	pasteOn:          []byte{byte(27), byte('['), byte('?'), byte('2'), byte('0'), byte('0'), byte('4'), byte('h')},
+	pasteOff:         []byte{byte(27), byte('['), byte('?'), byte('2'), byte('0'), byte('0'), byte('4'), byte('l')},
+	mouseOn:          []byte{byte(27), byte('['), byte('?'), byte('1'), byte('0'), byte('0'), byte('2'), byte('h'), byte(27), byte('['), byte('?'), byte('1'), byte('0'), byte('0'), byte('6'), byte('h')},
+	mouseOff:         []byte{byte(27), byte('['), byte('?'), byte('1'), byte('0'), byte('0'), byte('6'), byte('l'), byte(27), byte('['), byte('?'), byte('1'), byte('0'), byte('0'), byte('2'), byte('l')},
 
 	colorReset:        []byte{byte(27), byte('['), byte('0'), byte('m')},
 	colorBold:         []byte{byte(27), byte('['), byte('1'), byte('m')},
@@ -33,6 +44,8 @@ var ANSI = ansi{
 	colorBoldOff:      []byte{byte(27), byte('['), byte('2'), byte('2'), byte('m')},
 	colorItalicsOff:   []byte{byte(27), byte('['), byte('2'), byte('3'), byte('m')},
 	colorUnderlineOff: []byte{byte(27), byte('['), byte('2'), byte('4'), byte('m')},
+	colorReverse:      []byte{byte(27), byte('['), byte('7'), byte('m')},
+	colorWarning:      []byte{byte(27), byte('['), byte('4'), byte('1'), byte('m')},
 }
 
 type ansi struct {
@@ -42,14 +55,21 @@ type ansi struct {
 	eot byte
 	enq byte
 	ack byte
+	bel byte
 	bs  byte
 	tab byte
 	lf  byte
 	vt  byte
 	cr  byte
+	etb byte
+	em  byte
 	esc byte
 	so  byte
 	dle byte
+	dc3 byte
+	nak byte
+	can byte
+	us  byte
 	del byte
 
 	left  byte
@@ -62,6 +82,10 @@ type ansi struct {
 	clearLine        []byte
 	hideCursor       []byte
 	showCursor       []byte
+	pasteOn          []byte
+	pasteOff         []byte
+	mouseOn          []byte
+	mouseOff         []byte
 
 	colorReset        []byte
 	colorBold         []byte
@@ -70,6 +94,8 @@ type ansi struct {
 	colorItalicsOff   []byte
 	colorUnderline    []byte
 	colorUnderlineOff []byte
+	colorReverse      []byte
+	colorWarning      []byte
 }
 
 func (a ansi) Escape(sequence []byte) []byte {
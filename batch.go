@@ -0,0 +1,95 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// batchWidth and batchHeight are the screen size scripts are replayed at, for keys like page down.
+const (
+	batchWidth  = 80
+	batchHeight = 24
+)
+
+// runBatch replays a key script over a buffer without a terminal, then writes the buffer
+// to its file, or to stdout if it doesn't have one or toStdout is set.
+func runBatch(scriptPath string, state editorState, toStdout bool, stdout io.Writer) error {
+	keys, err := loadScript(scriptPath)
+	if err != nil {
+		return err
+	}
+
+	if state.path != "" {
+		if owner := readLock(state.path); owner != "" && owner != lockOwner() && !lockIsStale(owner) {
+			return fmt.Errorf("%s is locked by %s", state.path, owner)
+		}
+		// a batch edit is over too quickly to need a lock of its own.
+		state.locked = true
+	}
+
+	state, err = playScript(keys, state.Resize(batchWidth, batchHeight))
+	if err != nil {
+		return err
+	}
+
+	if toStdout || state.path == "" {
+		return state.writeEncoded(stdout)
+	}
+	if !state.Modified() {
+		return nil
+	}
+	state = state.save()
+	if state.Modified() {
+		return fmt.Errorf("%s: %s", state.path, state.message)
+	}
+	return nil
+}
+
+// loadScript reads keys in key notation from a script, skipping lines starting with `#`.
+func loadScript(path string) ([]key, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var notation strings.Builder
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		notation.WriteString(line)
+		notation.WriteByte('\n')
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	keys, err := parseKeys(notation.String())
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return keys, nil
+}
+
+// playScript replays keys through the key handlers until they run out or one quits.
+func playScript(keys []key, state editorState) (editorState, error) {
+	var err error
+	for _, k := range keys {
+		state, err = processKey(k, state)
+		if err == errQuit {
+			break
+		}
+		if err != nil {
+			return state, err
+		}
+	}
+	if state.prompt != nil {
+		return state, fmt.Errorf("script ended at the prompt %q", state.prompt.label)
+	}
+	return state, nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func TestRunBatch(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	script := filepath.Join(dir, "edit.keys")
+	assert.Nil(os.WriteFile(path, []byte("one\ntwo\n"), 0644))
+	assert.Nil(os.WriteFile(script, []byte("# uppercase the second line\n<Down> M-k \"TWO\" <RET>\n"), 0644))
+
+	state, err := loadFile(path)
+	assert.Nil(err)
+	var stdout bytes.Buffer
+	assert.Nil(runBatch(script, state, true, &stdout))
+	assert.Equal("one\nTWO\n", stdout.String())
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("one\ntwo\n", string(written))
+
+	assert.Nil(runBatch(script, state, false, &stdout))
+	written, err = os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("one\nTWO\n", string(written))
+	assert.Empty(readLock(path))
+}
+
+func TestRunBatchKeepsLineEndingsAndEncoding(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	script := filepath.Join(dir, "edit.keys")
+	assert.Nil(os.WriteFile(script, []byte(`"x"`), 0644))
+
+	// stdout gets the file as it would be saved, not the rows of the buffer.
+	path := filepath.Join(dir, "dos.txt")
+	assert.Nil(os.WriteFile(path, []byte("a\r\nb\r\n"), 0644))
+	state, err := loadFile(path)
+	assert.Nil(err)
+	var stdout bytes.Buffer
+	assert.Nil(runBatch(script, state, true, &stdout))
+	assert.Equal("xa\r\nb\r\n", stdout.String())
+
+	path = filepath.Join(dir, "wide.txt")
+	assert.Nil(os.WriteFile(path, []byte("\xff\xfea\x00\n\x00"), 0644))
+	state, err = loadFile(path)
+	assert.Nil(err)
+	stdout.Reset()
+	assert.Nil(runBatch(script, state, true, &stdout))
+	assert.Equal("\xff\xfex\x00a\x00\n\x00", stdout.String())
+}
+
+func TestRunBatchEndsAtPrompt(t *testing.T) {
+	assert := assert.New(t)
+
+	script := filepath.Join(t.TempDir(), "goto.keys")
+	assert.Nil(os.WriteFile(script, []byte("M-g"), 0644))
+
+	var stdout bytes.Buffer
+	err := runBatch(script, stateFromString("one\n"), false, &stdout)
+	assert.NotNil(err)
+	assert.Empty(stdout.String())
+}
@@ -0,0 +1,114 @@
+package main
+
+import "bytes"
+
+const (
+	openBrackets  = "([{"
+	closeBrackets = ")]}"
+)
+
+// bracket is a bracket in the code of a buffer, outside strings and comments.
+type bracket struct {
+	at cursor
+	c  byte
+	// match is the index of the partner bracket, or -1 if it's unbalanced.
+	match int
+}
+
+// Brackets returns the brackets in the code of the buffer, paired with their partners.
+func (b buffer) Brackets(lang language) []bracket {
+	var brackets []bracket
+	var open []int // the stack of unclosed brackets
+
+	var quote byte // the quote of the string we're in, if any
+	var inBlockComment bool
+	for y := 0; y < len(b); y++ {
+		row := b[y]
+		if quote != '`' {
+			quote = 0 // only raw strings span rows
+		}
+		for x := 0; x < len(row); x++ {
+			c := row[x]
+			switch {
+			case inBlockComment:
+				if c == '*' && x+1 < len(row) && row[x+1] == '/' {
+					inBlockComment = false
+					x++
+				}
+			case quote != 0:
+				if c == '\\' && quote != '`' {
+					x++ // skip the escaped byte
+				} else if c == quote {
+					quote = 0
+				}
+			case lang.lineComment != "" && bytes.HasPrefix(row[x:], []byte(lang.lineComment)):
+				x = len(row)
+			case lang.blockComments && c == '/' && x+1 < len(row) && row[x+1] == '*':
+				inBlockComment = true
+				x++
+			case bytes.IndexByte([]byte(lang.quotes), c) >= 0:
+				quote = c
+			case bytes.IndexByte([]byte(openBrackets), c) >= 0:
+				open = append(open, len(brackets))
+				brackets = append(brackets, bracket{at: cursor{row: y, col: x}, c: c, match: -1})
+			case bytes.IndexByte([]byte(closeBrackets), c) >= 0:
+				current := bracket{at: cursor{row: y, col: x}, c: c, match: -1}
+				if len(open) > 0 && bracketPartner(brackets[open[len(open)-1]].c) == c {
+					current.match = open[len(open)-1]
+					brackets[current.match].match = len(brackets)
+					open = open[0 : len(open)-1]
+				}
+				brackets = append(brackets, current)
+			}
+		}
+	}
+	return brackets
+}
+
+// bracketPartner returns the closing bracket for an opening bracket and vice versa.
+func bracketPartner(c byte) byte {
+	if index := bytes.IndexByte([]byte(openBrackets), c); index >= 0 {
+		return closeBrackets[index]
+	}
+	if index := bytes.IndexByte([]byte(closeBrackets), c); index >= 0 {
+		return openBrackets[index]
+	}
+	return 0
+}
+
+// BracketAtCursor returns the bracket under the cursor, or else the one before it, and its partner.
+// ok is false if there isn't a bracket at the cursor, and matched is false if it's unbalanced.
+func (es editorState) BracketAtCursor() (at, match cursor, matched, ok bool) {
+	if es.large != nil { // finding brackets reads the whole buffer
+		return cursor{}, cursor{}, false, false
+	}
+	brackets := es.buffer.Brackets(es.settings.language)
+	before := cursor{row: es.cursor.row, col: es.cursor.col - 1}
+	for _, candidate := range []cursor{es.cursor, before} {
+		for _, b := range brackets {
+			if b.at != candidate {
+				continue
+			}
+			if b.match < 0 {
+				return b.at, cursor{}, false, true
+			}
+			return b.at, brackets[b.match].at, true, true
+		}
+	}
+	return cursor{}, cursor{}, false, false
+}
+
+// JumpToMatchingBracket moves the cursor to the partner of the bracket at the cursor.
+func (es editorState) JumpToMatchingBracket() editorState {
+	_, match, matched, ok := es.BracketAtCursor()
+	if !ok {
+		es.message = "no bracket at cursor"
+		return es
+	}
+	if !matched {
+		es.message = "unbalanced bracket"
+		return es
+	}
+	es.cursor = match
+	return es
+}
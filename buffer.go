@@ -1,7 +1,32 @@
 package main
 
+import "bytes"
+
 type buffer [][]byte
 
+// bufferFromBytes splits text into rows on newlines.
+func bufferFromBytes(text []byte) buffer {
+	rows := bytes.Split(text, []byte{byteNewLine})
+	output := make([][]byte, len(rows))
+	for y, row := range rows {
+		output[y] = append([]byte{}, row...)
+	}
+	return output
+}
+
+// Bytes returns the contents of the buffer with the rows joined by newlines.
+func (b buffer) Bytes() []byte {
+	return bytes.Join(b, []byte{byteNewLine})
+}
+
+// Same returns if two buffers are the same edit, i.e. neither was derived from the other by a change.
+func (b buffer) Same(other buffer) bool {
+	if len(b) != len(other) {
+		return false
+	}
+	return len(b) == 0 || &b[0] == &other[0]
+}
+
 func (b buffer) RowLength(row int) int {
 	if len(b) == 0 {
 		return 0
@@ -41,10 +66,11 @@ func (b buffer) InsertCharacterAt(row, col int, c byte) buffer {
 	output := make([][]byte, len(b))
 	for y := 0; y < len(b); y++ {
 		if y == row {
+			// build a new row, the old row may still be referenced by an earlier state.
 			if col >= len(b[y]) {
-				output[y] = append(b[y], c)
+				output[y] = append(append(make([]byte, 0, len(b[y])+1), b[y]...), c)
 			} else {
-				output[y] = append(b[y][0:col], append([]byte{c}, b[y][col:]...)...) // zip
+				output[y] = append(append(append(make([]byte, 0, len(b[y])+1), b[y][0:col]...), c), b[y][col:]...) // zip
 			}
 		} else {
 			output[y] = b[y][:]
@@ -75,23 +101,22 @@ func (b buffer) RemoveRowAt(row int) buffer {
 }
 
 func (b buffer) RemoveCharacterAt(row, col int) buffer {
-	if len(b) == 0 {
+	if len(b) == 0 || row >= len(b) {
 		return b
 	}
 
-	if col < 0 {
+	// there's nothing to remove before the start or past the end of the row.
+	if col < 0 || col >= len(b[row]) {
 		return b
 	}
 
 	output := make([][]byte, len(b))
 	for y := 0; y < len(b); y++ {
 		if y == row {
-			if len(b[y]) > 0 {
-				if col == len(b[y])-1 {
-					output[y] = b[y][0:col]
-				} else {
-					output[y] = append(b[y][0:col], b[y][col+1:]...) // snip
-				}
+			if col == len(b[y])-1 {
+				output[y] = b[y][0:col:col] // capped, so appending to it can't write over the old row
+			} else {
+				output[y] = append(append(make([]byte, 0, len(b[y])-1), b[y][0:col]...), b[y][col+1:]...) // snip
 			}
 		} else {
 			output[y] = b[y][:]
@@ -108,9 +133,10 @@ func (b buffer) TrimRowAt(row, col int) buffer {
 	output := make([][]byte, len(b))
 	for y := 0; y < len(b); y++ {
 		if y == row {
-			if len(b[y]) > 0 {
-				output[y] = b[y][0:col]
+			if col > len(b[y]) {
+				col = len(b[y])
 			}
+			output[y] = b[y][0:col:col]
 		} else {
 			output[y] = b[y][:]
 		}
@@ -119,14 +145,15 @@ func (b buffer) TrimRowAt(row, col int) buffer {
 }
 
 func (b buffer) MoveRowToEndOfPrevious(row int) buffer {
-	if len(b) < 2 {
+	if len(b) < 2 || row < 1 || row >= len(b) {
 		return b
 	}
 
 	output := make([][]byte, len(b)-1)
 	for y := 0; y < len(b); y++ { // <= means extra row
 		if y == row {
-			output[y-1] = append(output[y-1], b[y][:]...)
+			// build a new row, appending to the previous one could write over a row an earlier state still has.
+			output[y-1] = append(append(make([]byte, 0, len(b[y-1])+len(b[y])), b[y-1]...), b[y]...)
 		} else if y > row {
 			output[y-1] = b[y][:]
 		} else {
@@ -144,7 +171,7 @@ func (b buffer) MoveAfterToNextRow(row, col int) buffer {
 	output := make([][]byte, len(b)+1)
 	for y := 0; y <= len(b); y++ { // <= means extra row
 		if y == row {
-			output[y] = b[y][0:col]
+			output[y] = b[y][0:col:col]
 			continue
 		}
 		if y == row+1 {
@@ -159,3 +186,211 @@ func (b buffer) MoveAfterToNextRow(row, col int) buffer {
 	}
 	return output
 }
+
+// InsertAt inserts text, which must not contain newlines, into a row.
+func (b buffer) InsertAt(row, col int, text []byte) buffer {
+	if len(text) == 0 {
+		return b
+	}
+
+	inserted := make([]byte, 0, len(b[row])+len(text))
+	inserted = append(inserted, b[row][0:col]...)
+	inserted = append(inserted, text...)
+	inserted = append(inserted, b[row][col:]...)
+
+	output := make([][]byte, len(b))
+	copy(output, b)
+	output[row] = inserted
+	return output
+}
+
+// IsBlankRow returns if a row is empty or only whitespace.
+func (b buffer) IsBlankRow(row int) bool {
+	return len(leadingWhitespace(b[row])) == len(b[row])
+}
+
+// VisualColumn returns the screen column of a column in a row, expanding tabs.
+func (b buffer) VisualColumn(row, col, tabWidth int) int {
+	var visual int
+	for x := 0; x < col && x < len(b[row]); x++ {
+		visual += glyphWidth(b[row][x], tabWidth)
+	}
+	return visual
+}
+
+// glyphWidth returns how many screen columns a byte is drawn in; tabs are expanded,
+// and control bytes are drawn in caret notation like `^M` so they can't move the terminal cursor.
+func glyphWidth(c byte, tabWidth int) int {
+	switch {
+	case c == byteTab:
+		return tabWidth
+	case c < ' ' || c == ANSI.del:
+		return 2
+	}
+	return 1
+}
+
+// RemoveRange removes the text from one position up to another, joining the rows at either end.
+func (b buffer) RemoveRange(from, to cursor) buffer {
+	if to.row < from.row || (to.row == from.row && to.col < from.col) {
+		from, to = to, from
+	}
+
+	joined := make([]byte, 0, from.col+len(b[to.row])-to.col)
+	joined = append(joined, b[from.row][0:from.col]...)
+	joined = append(joined, b[to.row][to.col:]...)
+
+	output := make([][]byte, 0, len(b)-(to.row-from.row))
+	output = append(output, b[0:from.row]...)
+	output = append(output, joined)
+	output = append(output, b[to.row+1:]...)
+	return output
+}
+
+// Offset returns the byte offset of a position in the joined contents of the buffer.
+func (b buffer) Offset(at cursor) int {
+	var offset int
+	for y := 0; y < at.row; y++ {
+		offset += len(b[y]) + 1
+	}
+	return offset + at.col
+}
+
+// CursorAtNonBlank returns the position after the given number of non-blank bytes.
+func (b buffer) CursorAtNonBlank(count int) cursor {
+	for y := 0; y < len(b); y++ {
+		for x := 0; x < len(b[y]); x++ {
+			if count == 0 {
+				return cursor{row: y, col: x}
+			}
+			if !isBlank(b[y][x]) {
+				count--
+			}
+		}
+		if count == 0 {
+			return cursor{row: y, col: len(b[y])}
+		}
+	}
+	last := len(b) - 1
+	return cursor{row: last, col: len(b[last])}
+}
+
+// Text returns the text from one position up to another, with rows joined by newlines.
+func (b buffer) Text(from, to cursor) []byte {
+	if to.row < from.row || (to.row == from.row && to.col < from.col) {
+		from, to = to, from
+	}
+	if from.row == to.row {
+		return append([]byte{}, b[from.row][from.col:to.col]...)
+	}
+
+	text := append([]byte{}, b[from.row][from.col:]...)
+	for y := from.row + 1; y < to.row; y++ {
+		text = append(text, byteNewLine)
+		text = append(text, b[y]...)
+	}
+	text = append(text, byteNewLine)
+	return append(text, b[to.row][0:to.col]...)
+}
+
+// InsertText inserts text, which may contain newlines, at a position.
+// It returns the new buffer and the position at the end of the inserted text.
+func (b buffer) InsertText(at cursor, text []byte) (buffer, cursor) {
+	lines := bytes.Split(text, []byte{byteNewLine})
+	if len(lines) == 1 {
+		return b.InsertAt(at.row, at.col, text), cursor{row: at.row, col: at.col + len(text)}
+	}
+
+	last := lines[len(lines)-1]
+	end := cursor{row: at.row + len(lines) - 1, col: len(last)}
+
+	rows := make([][]byte, len(lines))
+	rows[0] = append(append([]byte{}, b[at.row][0:at.col]...), lines[0]...)
+	for y := 1; y < len(lines)-1; y++ {
+		rows[y] = append([]byte{}, lines[y]...)
+	}
+	rows[len(lines)-1] = append(append([]byte{}, last...), b[at.row][at.col:]...)
+
+	output := make([][]byte, 0, len(b)+len(lines)-1)
+	output = append(output, b[0:at.row]...)
+	output = append(output, rows...)
+	output = append(output, b[at.row+1:]...)
+	return output, end
+}
+
+// ByteAt returns the byte at a position, or a newline at the end of a row.
+func (b buffer) ByteAt(at cursor) byte {
+	if at.col >= len(b[at.row]) {
+		return byteNewLine
+	}
+	return b[at.row][at.col]
+}
+
+// Next returns the position after another, moving to the next row after the end of a row.
+// It returns false at the end of the buffer.
+func (b buffer) Next(at cursor) (cursor, bool) {
+	if at.col < len(b[at.row]) {
+		return cursor{row: at.row, col: at.col + 1}, true
+	}
+	if at.row < len(b)-1 {
+		return cursor{row: at.row + 1}, true
+	}
+	return at, false
+}
+
+// Prev returns the position before another, moving to the end of the previous row from the start of a row.
+// It returns false at the start of the buffer.
+func (b buffer) Prev(at cursor) (cursor, bool) {
+	if at.col > 0 {
+		return cursor{row: at.row, col: at.col - 1}, true
+	}
+	if at.row > 0 {
+		return cursor{row: at.row - 1, col: len(b[at.row-1])}, true
+	}
+	return at, false
+}
+
+// ReplaceByte replaces the byte at a position, which is a newline at the end of a row.
+// Replacing a byte within a row with another only copies that row.
+func (b buffer) ReplaceByte(at cursor, c byte) buffer {
+	if at.col < len(b[at.row]) && c != byteNewLine {
+		output := append(make(buffer, 0, len(b)), b...)
+		// build a new row, the old row may still be referenced by an earlier state.
+		output[at.row] = append(make([]byte, 0, len(b[at.row])), b[at.row]...)
+		output[at.row][at.col] = c
+		return output
+	}
+	next, _ := b.Next(at)
+	output, _ := b.RemoveRange(at, next).InsertText(at, []byte{c})
+	return output
+}
+
+// Clamp returns the nearest position to another that's in the buffer.
+func (b buffer) Clamp(at cursor) cursor {
+	if at.row > len(b)-1 {
+		at.row = len(b) - 1
+	}
+	if at.row < 0 {
+		at.row = 0
+	}
+	if at.col > len(b[at.row]) {
+		at.col = len(b[at.row])
+	}
+	if at.col < 0 {
+		at.col = 0
+	}
+	return at
+}
+
+// ColumnAtVisual returns the column in a row shown at a screen column, expanding tabs.
+func (b buffer) ColumnAtVisual(row, visual, tabWidth int) int {
+	var width int
+	for x := 0; x < len(b[row]); x++ {
+		next := width + glyphWidth(b[row][x], tabWidth)
+		if visual < next {
+			return x
+		}
+		width = next
+	}
+	return len(b[row])
+}
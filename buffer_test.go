@@ -179,3 +179,162 @@ func TestBufferTrimLineAt(t *testing.T) {
 	assert.Len(edited[1], 2)
 	assert.Len(edited[2], 5)
 }
+
+func TestBufferRemoveRange(t *testing.T) {
+	assert := assert.New(t)
+
+	var b buffer = [][]byte{
+		[]byte("abcd"),
+		[]byte("efgh"),
+		[]byte("ijkl"),
+	}
+
+	edited := b.RemoveRange(cursor{row: 0, col: 1}, cursor{row: 0, col: 3})
+	assert.Len(edited, 3)
+	assert.Equal("ad", string(edited[0]))
+	assert.Equal("abcd", string(b[0]))
+
+	edited = b.RemoveRange(cursor{row: 2, col: 2}, cursor{row: 0, col: 2})
+	assert.Len(edited, 1)
+	assert.Equal("abkl", string(edited[0]))
+	assert.Equal("abcd", string(b[0]))
+
+	edited = b.RemoveRange(cursor{row: 1, col: 4}, cursor{row: 2, col: 0})
+	assert.Len(edited, 2)
+	assert.Equal("efghijkl", string(edited[1]))
+}
+
+func TestBufferInsertText(t *testing.T) {
+	assert := assert.New(t)
+
+	var b buffer = [][]byte{
+		[]byte("abcd"),
+		[]byte("efgh"),
+	}
+
+	edited, end := b.InsertText(cursor{row: 0, col: 2}, []byte("xy"))
+	assert.Equal("abxycd", string(edited[0]))
+	assert.Equal(cursor{row: 0, col: 4}, end)
+
+	edited, end = b.InsertText(cursor{row: 0, col: 2}, []byte("x\ny\nz"))
+	assert.Len(edited, 4)
+	assert.Equal("abx", string(edited[0]))
+	assert.Equal("y", string(edited[1]))
+	assert.Equal("zcd", string(edited[2]))
+	assert.Equal("efgh", string(edited[3]))
+	assert.Equal(cursor{row: 2, col: 1}, end)
+	assert.Equal("abcd", string(b[0]))
+
+	assert.Equal("x\ny\nz", string(edited.Text(cursor{row: 0, col: 2}, end)))
+}
+
+func TestBufferEditsPastEndOfRow(t *testing.T) {
+	assert := assert.New(t)
+
+	b := bufferFromBytes([]byte("ab\n"))
+	assert.Equal("ab\n", string(b.RemoveCharacterAt(0, 2).Bytes()))
+	assert.Equal("ab\n", string(b.RemoveCharacterAt(0, 5).Bytes()))
+	assert.Equal("ab\n", string(b.TrimRowAt(0, 5).Bytes()))
+	assert.Equal("ab\n", string(b.TrimRowAt(1, 1).Bytes()))
+	assert.Equal("ab\n", string(b.MoveRowToEndOfPrevious(0).Bytes()))
+}
+
+func TestBufferJoinDoesNotChangeEarlierRows(t *testing.T) {
+	assert := assert.New(t)
+
+	b := bufferFromBytes([]byte("abc\nd"))
+	trimmed := b.RemoveCharacterAt(0, 2) // "ab", sharing "abc"'s bytes
+	joined := trimmed.MoveRowToEndOfPrevious(1)
+	assert.Equal("abd", string(joined.Bytes()))
+	assert.Equal("ab\nd", string(trimmed.Bytes()))
+	assert.Equal("abc\nd", string(b.Bytes()))
+}
+
+// FuzzBuffer applies random edits to a buffer and to its text as a string, which must agree,
+// and checks that no edit changes the buffers it was made from.
+func FuzzBuffer(f *testing.F) {
+	f.Add([]byte("one\ntwo\n"), []byte{0, 0, 1, 'x', 1, 0, 9, 0, 3, 1, 0, 0, 4, 0, 2, 0})
+	f.Add([]byte("\n\n"), []byte{2, 1, 5, 0, 1, 1, 1, 0, 3, 2, 0, 0, 0, 1, 0, 'y'})
+	f.Add([]byte("ab"), []byte{4, 0, 1, 0, 0, 1, 0, 'c', 3, 1, 0, 0, 0, 0, 0, 'd'})
+	f.Add([]byte("a\tb\nc"), []byte{7, 0, 1, '\n', 8, 0, 3, 1, 6, 1, 0, 0, 2, 0, 0, 0})
+
+	f.Fuzz(func(t *testing.T, text, ops []byte) {
+		b := bufferFromBytes(text)
+		model := string(text)
+
+		var history []buffer
+		var models []string
+		for len(ops) >= 4 {
+			op, row, col, arg := ops[0]%9, int(ops[1])%len(b), int(ops[2]), ops[3]
+			ops = ops[4:]
+			// columns can be one past the end of the row, to check they're handled.
+			col = col % (len(b[row]) + 2)
+			valid := minInt(col, len(b[row]))
+			offset := b.Offset(cursor{row: row, col: valid})
+
+			history = append(history, b)
+			models = append(models, model)
+
+			switch op {
+			case 0:
+				b = b.InsertCharacterAt(row, col, arg)
+				model = model[0:offset] + string([]byte{arg}) + model[offset:]
+			case 1:
+				b = b.RemoveCharacterAt(row, col)
+				if col < len(history[len(history)-1][row]) {
+					model = model[0:offset] + model[offset+1:]
+				}
+			case 2:
+				end := b.Offset(cursor{row: row, col: len(b[row])})
+				b = b.TrimRowAt(row, col)
+				model = model[0:offset] + model[end:]
+			case 3:
+				if row > 0 {
+					model = model[0:b.Offset(cursor{row: row})-1] + model[b.Offset(cursor{row: row}):]
+				}
+				b = b.MoveRowToEndOfPrevious(row)
+			case 4:
+				b = b.MoveAfterToNextRow(row, valid)
+				model = model[0:offset] + "\n" + model[offset:]
+			case 5:
+				start := b.Offset(cursor{row: row})
+				b = b.InsertRowAt(row)
+				model = model[0:start] + "\n" + model[start:]
+			case 6:
+				if len(b) == 1 {
+					continue
+				}
+				start, end := b.Offset(cursor{row: row}), b.Offset(cursor{row: row, col: len(b[row])})
+				if row == len(b)-1 {
+					start-- // the last row takes the newline before it
+				} else {
+					end++
+				}
+				b = b.RemoveRowAt(row)
+				model = model[0:start] + model[end:]
+			case 7:
+				inserted := []byte{arg, '\n', arg}
+				b, _ = b.InsertText(cursor{row: row, col: valid}, inserted)
+				model = model[0:offset] + string(inserted) + model[offset:]
+			case 8:
+				to := cursor{row: int(arg) % len(b)}
+				to.col = col % (len(b[to.row]) + 1)
+				toOffset := b.Offset(to)
+				b = b.RemoveRange(cursor{row: row, col: valid}, to)
+				if toOffset < offset {
+					offset, toOffset = toOffset, offset
+				}
+				model = model[0:offset] + model[toOffset:]
+			}
+
+			if string(b.Bytes()) != model {
+				t.Fatalf("op %d at %d:%d: got %q, want %q", op, row, col, b.Bytes(), model)
+			}
+		}
+		for x := range history {
+			if string(history[x].Bytes()) != models[x] {
+				t.Fatalf("edit %d was changed to %q from %q", x, history[x].Bytes(), models[x])
+			}
+		}
+	})
+}
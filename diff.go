@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// diffContext is the number of unchanged rows shown around changes.
+const diffContext = 3
+
+// diffLines returns a unified diff between two sets of rows, or nil if they're the same.
+func diffLines(aName, bName string, a, b [][]byte) []byte {
+	ops := diffOps(a, b)
+
+	var changed bool
+	for _, op := range ops {
+		if op.kind != ' ' {
+			changed = true
+			break
+		}
+	}
+	if !changed {
+		return nil
+	}
+
+	output := bytes.NewBuffer(nil)
+	fmt.Fprintf(output, "--- %s\n+++ %s\n", aName, bName)
+	for start := 0; start < len(ops); {
+		// find the next change, and the run of changes near it.
+		for start < len(ops) && ops[start].kind == ' ' {
+			start++
+		}
+		if start == len(ops) {
+			break
+		}
+		end := start
+		for unchanged := 0; end < len(ops) && unchanged <= 2*diffContext; end++ {
+			if ops[end].kind == ' ' {
+				unchanged++
+			} else {
+				unchanged = 0
+			}
+		}
+		for end > start && ops[end-1].kind == ' ' {
+			end--
+		}
+
+		from := start - diffContext
+		if from < 0 {
+			from = 0
+		}
+		to := end + diffContext
+		if to > len(ops) {
+			to = len(ops)
+		}
+
+		var aCount, bCount int
+		for _, op := range ops[from:to] {
+			if op.kind != '+' {
+				aCount++
+			}
+			if op.kind != '-' {
+				bCount++
+			}
+		}
+		fmt.Fprintf(output, "@@ -%d,%d +%d,%d @@\n", ops[from].a+1, aCount, ops[from].b+1, bCount)
+		for _, op := range ops[from:to] {
+			output.WriteByte(op.kind)
+			if op.kind == '+' {
+				output.Write(b[op.b])
+			} else {
+				output.Write(a[op.a])
+			}
+			output.WriteByte(byteNewLine)
+		}
+		start = to
+	}
+	return output.Bytes()
+}
+
+// diffOp is a row kept (' '), removed ('-') or added ('+'), with its index in each set of rows.
+type diffOp struct {
+	kind byte
+	a, b int
+}
+
+// diffMaxCost bounds the edits looked for between two runs of rows, so a diff takes time and
+// memory in proportion to the rows; runs that differ by more are shown as removed then added,
+// which is still a diff between them, but not the shortest.
+const diffMaxCost = 1000
+
+// diffOps returns the edits from one set of rows to another, using Myers' algorithm in linear space.
+func diffOps(a, b [][]byte) []diffOp {
+	// rows are compared as numbers, the same for the same text.
+	numbers := map[string]int{}
+	number := func(rows [][]byte) []int {
+		output := make([]int, len(rows))
+		for y, row := range rows {
+			n, ok := numbers[string(row)]
+			if !ok {
+				n = len(numbers)
+				numbers[string(row)] = n
+			}
+			output[y] = n
+		}
+		return output
+	}
+	d := differ{a: number(a), b: number(b), ops: make([]diffOp, 0, len(a)+len(b))}
+	d.diff(0, len(a), 0, len(b))
+	return d.ops
+}
+
+// differ collects the edits between two sets of rows, as numbers.
+type differ struct {
+	a, b []int
+	ops  []diffOp
+}
+
+// diff adds the edits from a[a0:a1] to b[b0:b1], splitting them at the middle of the shortest edit.
+func (d *differ) diff(a0, a1, b0, b1 int) {
+	// the common prefix and suffix are usually most of it.
+	for a0 < a1 && b0 < b1 && d.a[a0] == d.b[b0] {
+		d.ops = append(d.ops, diffOp{kind: ' ', a: a0, b: b0})
+		a0, b0 = a0+1, b0+1
+	}
+	var suffix int
+	for a1 > a0 && b1 > b0 && d.a[a1-1] == d.b[b1-1] {
+		a1, b1 = a1-1, b1-1
+		suffix++
+	}
+
+	if x, y, ok := d.middle(a0, a1, b0, b1); ok {
+		d.diff(a0, x, b0, y)
+		d.diff(x, a1, y, b1)
+	} else {
+		for x := a0; x < a1; x++ {
+			d.ops = append(d.ops, diffOp{kind: '-', a: x, b: b0})
+		}
+		for y := b0; y < b1; y++ {
+			d.ops = append(d.ops, diffOp{kind: '+', a: a1, b: y})
+		}
+	}
+
+	for s := 0; s < suffix; s++ {
+		d.ops = append(d.ops, diffOp{kind: ' ', a: a1 + s, b: b1 + s})
+	}
+}
+
+// middle returns a point on the shortest edit from a[a0:a1] to b[b0:b1] that splits it into
+// two shorter edits, found by searching from both ends at once. It's false if either run is
+// empty, as there's nothing to split, or if the edit is longer than diffMaxCost.
+func (d *differ) middle(a0, a1, b0, b1 int) (x, y int, ok bool) {
+	n, m := a1-a0, b1-b0
+	if n == 0 || m == 0 {
+		return 0, 0, false
+	}
+	// forward[k] is how far along a the furthest forward edit reaches on diagonal k, where k is
+	// x-y; backward[c] is the same for the edit from the ends of both runs, reversed.
+	delta, odd := n-m, (n-m)&1 != 0
+	cost := minInt((n+m+1)/2, diffMaxCost)
+	offset := cost + 1
+	forward, backward := make([]int, 2*cost+3), make([]int, 2*cost+3)
+	for e := 0; e <= cost; e++ {
+		for k := -e; k <= e; k += 2 {
+			x := forward[offset+k-1] + 1
+			if k == -e || (k != e && forward[offset+k-1] < forward[offset+k+1]) {
+				x = forward[offset+k+1]
+			}
+			start := x
+			for x < n && x-k < m && d.a[a0+x] == d.b[b0+x-k] {
+				x++
+			}
+			forward[offset+k] = x
+			if c := delta - k; odd && c >= -(e-1) && c <= e-1 && x+backward[offset+c] >= n {
+				return a0 + start, b0 + start - k, true
+			}
+		}
+		for c := -e; c <= e; c += 2 {
+			x := backward[offset+c-1] + 1
+			if c == -e || (c != e && backward[offset+c-1] < backward[offset+c+1]) {
+				x = backward[offset+c+1]
+			}
+			for x < n && x-c < m && d.a[a1-1-x] == d.b[b1-1-x+c] {
+				x++
+			}
+			backward[offset+c] = x
+			if k := delta - c; !odd && k >= -e && k <= e && x+forward[offset+k] >= n {
+				return a1 - x, b1 - x + c, true
+			}
+		}
+	}
+	return 0, 0, false
+}
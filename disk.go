@@ -0,0 +1,149 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"time"
+)
+
+// fileStamp identifies the contents of a file on disk, so we can tell if something else changes it.
+type fileStamp struct {
+	exists  bool
+	modTime time.Time
+	size    int64
+	hash    [sha256.Size]byte
+	hashed  bool // large files aren't hashed
+}
+
+// stampFile returns the stamp of a file, which is the zero stamp if the file doesn't exist.
+func stampFile(path string) (fileStamp, error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return fileStamp{}, nil
+	}
+	if err != nil {
+		return fileStamp{}, err
+	}
+	stamp := fileStamp{
+		exists:  true,
+		modTime: info.ModTime(),
+		size:    info.Size(),
+	}
+	if info.Size() > largeFileSize {
+		return stamp, nil
+	}
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return fileStamp{}, err
+	}
+	stamp.hash = sha256.Sum256(contents)
+	stamp.hashed = true
+	return stamp, nil
+}
+
+// diskChange returns the current stamp of the file, and if its contents differ from when it was loaded or saved.
+func (es editorState) diskChange() (fileStamp, bool) {
+	info, err := os.Stat(es.path)
+	if err != nil { // removed files are recreated by saving.
+		return es.disk, false
+	}
+	if es.disk.exists && info.ModTime().Equal(es.disk.modTime) && info.Size() == es.disk.size {
+		return es.disk, false
+	}
+	current, err := stampFile(es.path)
+	if err != nil || !current.exists {
+		return es.disk, false
+	}
+	// the file may have only been touched.
+	return current, !es.disk.exists || !current.hashed || current.hash != es.disk.hash
+}
+
+// CheckDisk looks for changes made to the file by something else, reloading it if there are no unsaved changes.
+// Large files aren't reloaded, since a file that's being appended to would be mapped again on every check.
+func (es editorState) CheckDisk() editorState {
+	if es.path == "" || es.viewing != nil || es.diskChanged {
+		return es
+	}
+	current, changed := es.diskChange()
+	if !changed {
+		es.disk = current
+		return es
+	}
+	if es.Modified() {
+		es.diskChanged = true
+		return es
+	}
+	if es.large != nil {
+		es.diskChanged = true
+		es.message = fmt.Sprintf("%s changed on disk, M-x revert to reload it", es.path)
+		return es
+	}
+	es = es.Reload()
+	es.message = fmt.Sprintf("%s changed on disk, reloaded", es.path)
+	return es
+}
+
+// Reload replaces the buffer with the file on disk; it can be undone.
+func (es editorState) Reload() editorState {
+	loaded, err := loadFile(es.path)
+	if err != nil {
+		es.message = err.Error()
+		return es
+	}
+
+	previous := es
+	previous.message = ""
+	es.buffer = loaded.buffer
+	es.saved = loaded.saved
+	es.autosaved = loaded.autosaved
+	es.disk = loaded.disk
+	es.large = loaded.large
+	es.encoding = loaded.encoding
+	es.lineEnding = loaded.lineEnding
+	es.savedLineEnding = loaded.savedLineEnding
+	es.diskChanged = false
+	es.undo = &previous
+	if previous.large != nil {
+		// the old text is unmapped, so the reload can't be undone.
+		previous.closeLarge()
+		es.undo = nil
+	}
+	if previous.large != nil {
+		// the cursor stays on the same row of the file, rather than of the window.
+		at := previous.large.fileCursor(previous.cursor)
+		es.cursor, es.mark = es.buffer.Clamp(at), cursor{}
+		es.scroll = previous.large.first + previous.scroll
+		if es.large != nil {
+			es = es.takeLargeWindow(at)
+		}
+	}
+	es.selecting = false
+	return es.viClampCursor().ScrollToCursor()
+}
+
+// chooseDiskChange asks what to do about changes on disk before saving over them.
+func (es editorState) chooseDiskChange() editorState {
+	return es.Choose(fmt.Sprintf("%s changed on disk: (r)eload, (k)eep yours and save, (d)iff? ", es.path), "rkd", func(es editorState, choice byte) editorState {
+		switch choice {
+		case 'r':
+			return es.Reload()
+		case 'k':
+			return es.save()
+		default:
+			if es.large != nil {
+				es.message = "too large to diff"
+				return es
+			}
+			disk, err := loadFile(es.path)
+			if err != nil {
+				es.message = err.Error()
+				return es
+			}
+			diff := diffLines(es.path+" (on disk)", es.path+" (buffer)", disk.buffer, es.buffer)
+			return es.ShowText(diff, func(es editorState) editorState {
+				return es.chooseDiskChange()
+			})
+		}
+	})
+}
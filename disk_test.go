@@ -0,0 +1,60 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func writeFileAt(path, contents string, modTime time.Time) error {
+	if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+		return err
+	}
+	return os.Chtimes(path, modTime, modTime)
+}
+
+func TestCheckDiskReloadsUnmodified(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "gen.go")
+	assert.Nil(writeFileAt(path, "one\n", time.Now().Add(-time.Hour)))
+	state, err := loadFile(path)
+	assert.Nil(err)
+
+	state = state.CheckDisk()
+	assert.Equal("one\n", string(state.buffer.Bytes()))
+
+	assert.Nil(writeFileAt(path, "two\n", time.Now()))
+	state = state.CheckDisk()
+	assert.Equal("two\n", string(state.buffer.Bytes()))
+	assert.False(state.Modified())
+	assert.Equal("one\n", string(state.Undo().buffer.Bytes()))
+}
+
+func TestCheckDiskWarnsModified(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "gen.go")
+	assert.Nil(writeFileAt(path, "one\n", time.Now().Add(-time.Hour)))
+	state, err := loadFile(path)
+	assert.Nil(err)
+	state = typeNotation(state, `"mine "`)
+
+	assert.Nil(writeFileAt(path, "two\n", time.Now()))
+	state = state.CheckDisk()
+	assert.True(state.diskChanged)
+	assert.Equal("mine one\n", string(state.buffer.Bytes()))
+
+	state = typeNotation(state, `C-x C-s`)
+	assert.NotNil(state.prompt)
+	contents, _ := os.ReadFile(path)
+	assert.Equal("two\n", string(contents))
+
+	state = typeNotation(state, `k`)
+	assert.False(state.diskChanged)
+	contents, _ = os.ReadFile(path)
+	assert.Equal("mine one\n", string(contents))
+}
@@ -1,41 +1,214 @@
 package main
 
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
 func newEditorState() editorState {
+	b := buffer{
+		[]byte{},
+	}
 	return editorState{
-		buffer: [][]byte{
-			[]byte{},
-		},
+		buffer:    b,
+		saved:     b,
+		autosaved: b,
+		settings:  defaultSettings(),
 	}
 }
 
 type editorState struct {
-	buffer buffer
-	scroll int //denotes scrollTop, or where we start drawing the buffer
-	cursor cursor
+	path     string //the file being edited, empty for a new buffer
+	buffer   buffer
+	scroll   int //denotes scrollTop, or where we start drawing the buffer
+	cursor   cursor
+	settings settings
+
+	scrollLeft int //the screen column rows are drawn from, when the cursor is past the right edge
+
+	width, height int //the size of the screen, zero if unknown
+
+	message string  //shown in the status line until the next key
+	prompt  *prompt //if set, keys are read into the prompt rather than the buffer
+	prefix  byte    //a pending prefix key, like C-x, or zero
+
+	undo *editorState //the state before the last edit
+	quit bool         //set when the editor should exit
+
+	mark      cursor    //the other end of the selection from the cursor
+	selecting bool      //set while the text between the mark and the cursor is selected
+	clipboard clipboard //the last yanked or deleted text
+	vi        viState   //the state of the vi keymap, if enabled
+
+	arg    prefixArg        //the numeric argument for the next command
+	macro  macroState       //the keyboard macro being recorded
+	macros map[string][]key //saved macros by name
+
+	viewing *viewing //set while showing text in place of the buffer
+
+	saved     buffer //the buffer as it was last loaded or saved
+	autosaved buffer //the buffer as it was last written to the recovery file
+
+	ownsRecovery bool //set when the recovery file holds this session's changes, so it's removed once they're saved
+
+	disk        fileStamp //the file as it was last loaded or saved
+	diskChanged bool      //set when the file has changed on disk and there are unsaved changes
+
+	locked   bool       //set when we hold the lock on the file
+	readOnly bool       //set when the buffer can't be changed
+	large    *largeText //set when the buffer is a window of a mapped large file, which turns off whole-buffer work on every key
+
+	encoding textEncoding //the encoding the file is read and written in
+	hex      hexState     //set when the bytes of the buffer are edited as hex
+
+	lineEnding      lineEnding //the newline rows are written with
+	savedLineEnding lineEnding //the newline the file was last loaded or saved with
+
+	finder     *finder //set while picking a file to open
+	justOpened bool    //set when a key has opened another file in place of the buffer
+}
+
+// Selection returns the selected text, from the mark to the cursor, with the end exclusive.
+func (es editorState) Selection() (from, to cursor, ok bool) {
+	if !es.selecting && es.vi.mode != viVisual && es.vi.mode != viVisualLine {
+		return cursor{}, cursor{}, false
+	}
+
+	// the mark may have been left past the end of rows that have since been removed.
+	from, to = es.buffer.Clamp(es.mark), es.cursor
+	if cursorAfter(from, to) {
+		from, to = to, from
+	}
+	if es.selecting {
+		return from, to, true
+	}
+	if es.vi.mode == viVisualLine {
+		return cursor{row: from.row}, cursor{row: to.row, col: len(es.buffer[to.row])}, true
+	}
+	// vi selections include the byte under the cursor.
+	if to.col < len(es.buffer[to.row]) {
+		to.col++
+	}
+	return from, to, true
+}
+
+// Modified returns if the buffer, or its line ending, has changed since it was loaded or saved.
+func (es editorState) Modified() bool {
+	return !es.buffer.Same(es.saved) || es.lineEnding != es.savedLineEnding
+}
+
+// Undo returns to the state before the last edit.
+func (es editorState) Undo() editorState {
+	if es.undo == nil {
+		es.message = "nothing to undo"
+		return es
+	}
+	previous := *es.undo
+	previous.path = es.path
+	previous.width = es.width
+	previous.height = es.height
+	previous.scroll = es.scroll
+	previous.scrollLeft = es.scrollLeft
+	previous.vi = es.vi
+	previous.clipboard = es.clipboard
+	previous.saved = es.saved
+	previous.savedLineEnding = es.savedLineEnding
+	previous.autosaved = es.autosaved
+	previous.ownsRecovery = es.ownsRecovery
+	previous.disk = es.disk
+	previous.diskChanged = es.diskChanged
+	previous.locked = es.locked
+	previous.readOnly = es.readOnly
+	previous.encoding = es.encoding
+	previous.hex = es.hex
+	return previous
+}
+
+// Resize sets the screen size.
+func (es editorState) Resize(width, height int) editorState {
+	es.width = width
+	es.height = height
+	return es
+}
+
+// TextHeight returns the number of rows available for the buffer, or zero if unbounded.
+func (es editorState) TextHeight() int {
+	if es.height < 2 {
+		return 0
+	}
+	return es.height - 1 // the last row is the status line
+}
+
+// ScrollToCursor adjusts the scroll so the cursor is on screen.
+func (es editorState) ScrollToCursor() editorState {
+	rows := es.TextHeight()
+	row := es.cursor.row
+	if es.hex.enabled {
+		row = es.hexRow()
+	}
+	if row < es.scroll {
+		es.scroll = row
+	} else if rows > 0 && row >= es.scroll+rows {
+		es.scroll = row - rows + 1
+	}
+
+	// hex rows always fit.
+	if es.hex.enabled {
+		es.scrollLeft = 0
+		return es
+	}
+	visual := es.buffer.VisualColumn(es.cursor.row, es.cursor.col, es.settings.tabWidth)
+	if visual < es.scrollLeft {
+		es.scrollLeft = visual
+	} else if es.width > 0 && visual >= es.scrollLeft+es.width {
+		es.scrollLeft = visual - es.width + 1
+	}
+	return es
 }
 
 func (es editorState) Write(b byte) editorState {
-	return editorState{
-		buffer: es.buffer.InsertCharacterAt(es.cursor.row, es.cursor.col, b),
-		cursor: es.cursor.Right(),
+	es.buffer = es.buffer.InsertCharacterAt(es.cursor.row, es.cursor.col, b)
+	es.cursor = es.cursor.Right()
+	if es.settings.language.DedentsOn(b) && es.cursor.col == len(leadingWhitespace(es.buffer[es.cursor.row]))+1 {
+		return es.Dedent()
 	}
+	return es
 }
 
-func (es editorState) MoveLeft() editorState {
-	return editorState{
-		buffer: es.buffer,
-		cursor: es.cursor.Left(),
+// Dedent removes one level of indentation from the cursor row.
+func (es editorState) Dedent() editorState {
+	row := es.cursor.row
+	indent := leadingWhitespace(es.buffer[row])
+	if len(indent) == 0 {
+		return es
 	}
+
+	remove := 1
+	if strings.HasSuffix(string(indent), es.settings.indent) {
+		remove = len(es.settings.indent)
+	}
+	es.buffer = es.buffer.RemoveRange(cursor{row: row, col: len(indent) - remove}, cursor{row: row, col: len(indent)})
+	es.cursor.col -= remove
+	if es.cursor.col < 0 {
+		es.cursor.col = 0
+	}
+	return es
+}
+
+func (es editorState) MoveLeft() editorState {
+	es.cursor = es.cursor.Left()
+	return es
 }
 
 func (es editorState) MoveRight() editorState {
 	if es.cursor.col == len(es.buffer[es.cursor.row]) {
 		return es
 	}
-	return editorState{
-		buffer: es.buffer,
-		cursor: es.cursor.Right(),
-	}
+	es.cursor = es.cursor.Right()
+	return es
 }
 
 func (es editorState) MoveUp() editorState {
@@ -45,18 +218,14 @@ func (es editorState) MoveUp() editorState {
 
 	previousLineLength := len(es.buffer[es.cursor.row-1])
 	if es.cursor.col < previousLineLength {
-		return editorState{
-			buffer: es.buffer,
-			cursor: es.cursor.Up(),
-		}
+		es.cursor = es.cursor.Up()
+		return es
 	}
-	return editorState{
-		buffer: es.buffer,
-		cursor: cursor{
-			row: es.cursor.row - 1,
-			col: previousLineLength,
-		},
+	es.cursor = cursor{
+		row: es.cursor.row - 1,
+		col: previousLineLength,
 	}
+	return es
 }
 
 func (es editorState) MoveDown() editorState {
@@ -67,61 +236,214 @@ func (es editorState) MoveDown() editorState {
 	nextLineLength := len(es.buffer[es.cursor.row+1])
 
 	if es.cursor.col < nextLineLength {
-		return editorState{
-			buffer: es.buffer,
-			cursor: es.cursor.Down(),
-		}
+		es.cursor = es.cursor.Down()
+		return es
 	}
-	return editorState{
-		buffer: es.buffer,
-		cursor: cursor{
-			row: es.cursor.row + 1,
-			col: nextLineLength,
-		},
+	es.cursor = cursor{
+		row: es.cursor.row + 1,
+		col: nextLineLength,
 	}
+	return es
 }
 
 func (es editorState) MoveToBeginningOfLine() editorState {
-	return editorState{
-		buffer: es.buffer,
-		cursor: es.cursor.BeginningOfLine(),
+	if es.large != nil && es.cursor.row == 0 && es.large.col > 0 { // the row starts before the window
+		return es.takeLargeWindow(cursor{row: es.large.first})
 	}
+	es.cursor = es.cursor.BeginningOfLine()
+	return es
 }
 
 func (es editorState) MoveToEndOfLine() editorState {
-	return editorState{
-		buffer: es.buffer,
-		cursor: cursor{
-			row: es.cursor.row,
-			col: len(es.buffer[es.cursor.row]),
-		},
+	if es.large != nil && es.cursor.row == len(es.buffer)-1 && es.large.end < es.large.size() {
+		// the row may go on after the window.
+		return es.takeLargeWindow(cursor{row: es.large.fileCursor(es.cursor).row, col: math.MaxInt})
+	}
+	es.cursor = cursor{
+		row: es.cursor.row,
+		col: len(es.buffer[es.cursor.row]),
+	}
+	return es
+}
+
+// MoveWordForward moves the cursor to the end of the current or next word.
+func (es editorState) MoveWordForward() editorState {
+	row, col := es.cursor.row, es.cursor.col
+	// skip anything that isn't a word, including the ends of rows.
+	for {
+		if col >= len(es.buffer[row]) {
+			if row == len(es.buffer)-1 {
+				break
+			}
+			row, col = row+1, 0
+			continue
+		}
+		if es.settings.IsWordChar(es.buffer[row][col]) {
+			break
+		}
+		col++
+	}
+	for col < len(es.buffer[row]) && es.settings.IsWordChar(es.buffer[row][col]) {
+		col++
+	}
+	es.cursor = cursor{row: row, col: col}
+	return es
+}
+
+// MoveWordBackward moves the cursor to the start of the current or previous word.
+func (es editorState) MoveWordBackward() editorState {
+	row, col := es.cursor.row, es.cursor.col
+	for {
+		if col == 0 {
+			if row == 0 {
+				break
+			}
+			row = row - 1
+			col = len(es.buffer[row])
+			continue
+		}
+		if es.settings.IsWordChar(es.buffer[row][col-1]) {
+			break
+		}
+		col--
+	}
+	for col > 0 && es.settings.IsWordChar(es.buffer[row][col-1]) {
+		col--
+	}
+	es.cursor = cursor{row: row, col: col}
+	return es
+}
+
+// MoveParagraphForward moves the cursor to the blank row after the current or next paragraph.
+func (es editorState) MoveParagraphForward() editorState {
+	row := es.cursor.row
+	for row < len(es.buffer) && es.buffer.IsBlankRow(row) {
+		row++
+	}
+	for row < len(es.buffer) && !es.buffer.IsBlankRow(row) {
+		row++
+	}
+	if row == len(es.buffer) {
+		return es.MoveToEndOfBuffer()
+	}
+	es.cursor = cursor{row: row}
+	return es
+}
+
+// MoveParagraphBackward moves the cursor to the blank row before the current or previous paragraph.
+func (es editorState) MoveParagraphBackward() editorState {
+	row := es.cursor.row
+	for row >= 0 && es.buffer.IsBlankRow(row) {
+		row--
+	}
+	for row >= 0 && !es.buffer.IsBlankRow(row) {
+		row--
+	}
+	if row < 0 {
+		return es.MoveToBeginningOfBuffer()
+	}
+	es.cursor = cursor{row: row}
+	return es
+}
+
+func (es editorState) MoveToBeginningOfBuffer() editorState {
+	if es.large != nil {
+		return es.moveToLargeRow(0)
+	}
+	es.cursor = cursor{}
+	return es
+}
+
+func (es editorState) MoveToEndOfBuffer() editorState {
+	if es.large != nil {
+		es = es.takeLargeWindow(cursor{row: es.largeRows() - 1, col: math.MaxInt})
+	}
+	lastRow := len(es.buffer) - 1
+	es.cursor = cursor{
+		row: lastRow,
+		col: len(es.buffer[lastRow]),
 	}
+	return es
 }
 
+// GotoLine moves the cursor to the start of a line, numbered from 1, clamped to the buffer.
+func (es editorState) GotoLine(line int) editorState {
+	if es.large != nil {
+		return es.moveToLargeRow(line - 1)
+	}
+	row := line - 1
+	if row < 0 {
+		row = 0
+	}
+	if row > len(es.buffer)-1 {
+		row = len(es.buffer) - 1
+	}
+	es.cursor = cursor{row: row}
+	return es
+}
+
+// PromptGotoLine asks for a line number and moves to it.
+func (es editorState) PromptGotoLine() editorState {
+	return es.Prompt("Goto line: ", func(es editorState, value string) editorState {
+		line, err := strconv.Atoi(strings.TrimSpace(value))
+		if err != nil {
+			es.message = fmt.Sprintf("invalid line number: %q", value)
+			return es
+		}
+		return es.GotoLine(line)
+	})
+}
+
+// Newline splits the row at the cursor and indents the new row like the text before the cursor,
+// a level deeper if that text ends in a byte the language indents after, like `{`, or a level
+// shallower if the text moved down starts with a closing bracket. Whitespace at the start of the
+// moved text is replaced by the indent.
 func (es editorState) Newline() editorState {
+	before := es.buffer[es.cursor.row][0:es.cursor.col]
+	es = es.newline()
+
+	// the text moved down loses its own indent for the new one.
+	row := es.cursor.row
+	moved := leadingWhitespace(es.buffer[row])
+	if len(moved) > 0 {
+		es.buffer = es.buffer.RemoveRange(cursor{row: row}, cursor{row: row, col: len(moved)})
+	}
+
+	indent := append([]byte{}, leadingWhitespace(before)...)
+	if es.settings.language.IndentsAfter(before) {
+		indent = append(indent, es.settings.indent...)
+	}
+	es.buffer = es.buffer.InsertAt(row, 0, indent)
+	es.cursor.col = len(indent)
+
+	if len(es.buffer[row]) > len(indent) && es.settings.language.DedentsOn(es.buffer[row][len(indent)]) {
+		return es.Dedent()
+	}
+	return es
+}
+
+// newline splits the row at the cursor without indenting.
+func (es editorState) newline() editorState {
 	//newline does the following, it both creates a new line, but also does a bunch of line manipulation
 	// - on an existing line, if there is text after the cursor
 	//		which pushes existing content down one line
 	// - on an empty line i just creates a new line
 
 	if len(es.buffer[es.cursor.row]) == 0 {
-		return editorState{
-			buffer: es.buffer.InsertRowAt(es.cursor.row + 1),
-			cursor: es.cursor.DownBeginningOfLine(),
-		}
+		es.buffer = es.buffer.InsertRowAt(es.cursor.row + 1)
+		es.cursor = es.cursor.DownBeginningOfLine()
+		return es
 	}
 
 	if es.cursor.col == len(es.buffer[es.cursor.row]) { // if we're at the end of the row
-		return editorState{
-			buffer: es.buffer.InsertRowAt(es.cursor.row + 1),
-			cursor: es.cursor.DownBeginningOfLine(),
-		}
+		es.buffer = es.buffer.InsertRowAt(es.cursor.row + 1)
+		es.cursor = es.cursor.DownBeginningOfLine()
+		return es
 	}
 
-	return editorState{
-		buffer: es.buffer.MoveAfterToNextRow(es.cursor.row, es.cursor.col),
-		cursor: es.cursor.DownBeginningOfLine(),
-	}
+	es.buffer = es.buffer.MoveAfterToNextRow(es.cursor.row, es.cursor.col)
+	es.cursor = es.cursor.DownBeginningOfLine()
+	return es
 }
 
 func (es editorState) Backspace() editorState {
@@ -136,35 +458,125 @@ func (es editorState) Backspace() editorState {
 		endOfLine := len(es.buffer[previousRow])
 
 		if len(es.buffer[es.cursor.row]) == 0 {
-			return editorState{
-				buffer: es.buffer.RemoveRowAt(es.cursor.row),
-				cursor: cursor{
-					row: previousRow,
-					col: endOfLine,
-				},
+			es.buffer = es.buffer.RemoveRowAt(es.cursor.row)
+			es.cursor = cursor{
+				row: previousRow,
+				col: endOfLine,
 			}
+			return es
 		}
 
 		// move up a line.
-		return editorState{
-			buffer: es.buffer.MoveRowToEndOfPrevious(es.cursor.row),
-			cursor: cursor{
-				row: previousRow,
-				col: endOfLine,
-			},
+		es.buffer = es.buffer.MoveRowToEndOfPrevious(es.cursor.row)
+		es.cursor = cursor{
+			row: previousRow,
+			col: endOfLine,
 		}
+		return es
 	}
 
 	// nuke the character at the cursor, move the cursor to the left
-	return editorState{
-		buffer: es.buffer.RemoveCharacterAt(es.cursor.row, es.cursor.col-1),
-		cursor: es.cursor.Left(),
+	es.buffer = es.buffer.RemoveCharacterAt(es.cursor.row, es.cursor.col-1)
+	es.cursor = es.cursor.Left()
+	return es
+}
+
+// DeleteForward removes the character under the cursor, joining the next row at the end of a row.
+func (es editorState) DeleteForward() editorState {
+	row, col := es.cursor.row, es.cursor.col
+	if col < len(es.buffer[row]) {
+		es.buffer = es.buffer.RemoveRange(es.cursor, cursor{row: row, col: col + 1})
+		return es
 	}
+	if row == len(es.buffer)-1 { // nothing after the end of the buffer
+		return es
+	}
+	es.buffer = es.buffer.RemoveRange(es.cursor, cursor{row: row + 1})
+	return es
 }
 
-func (es editorState) TrimLine() editorState {
-	return editorState{
-		buffer: es.buffer.TrimRowAt(es.cursor.row, es.cursor.col),
-		cursor: es.cursor,
+// DeleteWordForward removes up to the end of the current or next word.
+func (es editorState) DeleteWordForward() editorState {
+	es.buffer = es.buffer.RemoveRange(es.cursor, es.MoveWordForward().cursor)
+	return es
+}
+
+// DeleteWordBackward removes back to the start of the current or previous word.
+func (es editorState) DeleteWordBackward() editorState {
+	start := es.MoveWordBackward().cursor
+	es.buffer = es.buffer.RemoveRange(start, es.cursor)
+	es.cursor = start
+	return es
+}
+
+// KillWholeLine removes the cursor row entirely, including its newline.
+func (es editorState) KillWholeLine() editorState {
+	row := es.cursor.row
+	switch {
+	case row < len(es.buffer)-1:
+		es.buffer = es.buffer.RemoveRange(cursor{row: row}, cursor{row: row + 1})
+	case row > 0: // the last row takes the newline before it
+		es.buffer = es.buffer.RemoveRange(cursor{row: row - 1, col: len(es.buffer[row-1])}, cursor{row: row, col: len(es.buffer[row])})
+		row--
+	default:
+		es.buffer = es.buffer.RemoveRange(cursor{}, cursor{col: len(es.buffer[0])})
+	}
+	es.cursor = cursor{row: row}
+	return es
+}
+
+// Paste inserts text at the cursor as it is, without indenting it.
+func (es editorState) Paste(text []byte) editorState {
+	// terminals send newlines in pastes as carriage returns.
+	text = bytes.Replace(text, []byte("\r\n"), []byte{byteNewLine}, -1)
+	text = bytes.Replace(text, []byte{ANSI.cr}, []byte{byteNewLine}, -1)
+	es.buffer, es.cursor = es.buffer.InsertText(es.cursor, text)
+	return es
+}
+
+// CopyRegion copies the selected text to the clipboard.
+func (es editorState) CopyRegion() editorState {
+	from, to, ok := es.Selection()
+	if !ok {
+		es.message = "no selection"
+		return es
 	}
+	es.clipboard = clipboard{text: es.buffer.Text(from, to)}
+	es.selecting = false
+	return es
+}
+
+// KillRegion removes the selected text, keeping it in the clipboard.
+func (es editorState) KillRegion() editorState {
+	from, to, ok := es.Selection()
+	if !ok {
+		es.message = "no selection"
+		return es
+	}
+	es.clipboard = clipboard{text: es.buffer.Text(from, to)}
+	es.buffer = es.buffer.RemoveRange(from, to)
+	es.cursor = from
+	es.selecting = false
+	return es
+}
+
+// Yank inserts the clipboard at the cursor.
+func (es editorState) Yank() editorState {
+	es.buffer, es.cursor = es.buffer.InsertText(es.cursor, es.clipboard.text)
+	return es
+}
+
+// KillLines removes the text from the cursor to the start of the row a number of rows down.
+func (es editorState) KillLines(count int) editorState {
+	to := cursor{row: es.cursor.row + count}
+	if to.row > len(es.buffer)-1 {
+		to = cursor{row: len(es.buffer) - 1, col: len(es.buffer[len(es.buffer)-1])}
+	}
+	es.buffer = es.buffer.RemoveRange(es.cursor, to)
+	return es
+}
+
+func (es editorState) TrimLine() editorState {
+	es.buffer = es.buffer.TrimRowAt(es.cursor.row, es.cursor.col)
+	return es
 }
@@ -0,0 +1,482 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func stateFromString(contents string) editorState {
+	return stateFromReader(strings.NewReader(contents))
+}
+
+func TestEditorStateMoveWordForward(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("foo_bar := baz\n\tqux\n")
+	state = state.MoveWordForward()
+	assert.Equal(cursor{row: 0, col: 7}, state.cursor)
+	state = state.MoveWordForward()
+	assert.Equal(cursor{row: 0, col: 14}, state.cursor)
+	state = state.MoveWordForward()
+	assert.Equal(cursor{row: 1, col: 4}, state.cursor)
+	state = state.MoveWordForward()
+	assert.Equal(cursor{row: 2, col: 0}, state.cursor)
+}
+
+func TestEditorStateMoveWordForwardWordChars(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("foo-bar baz")
+	state = state.MoveWordForward()
+	assert.Equal(cursor{row: 0, col: 3}, state.cursor)
+
+	state = state.MoveToBeginningOfLine()
+	state.settings.wordChars = "-"
+	state = state.MoveWordForward()
+	assert.Equal(cursor{row: 0, col: 7}, state.cursor)
+}
+
+func TestEditorStateMoveWordBackward(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("foo bar\n  baz")
+	state = state.MoveToEndOfBuffer()
+	state = state.MoveWordBackward()
+	assert.Equal(cursor{row: 1, col: 2}, state.cursor)
+	state = state.MoveWordBackward()
+	assert.Equal(cursor{row: 0, col: 4}, state.cursor)
+	state = state.MoveWordBackward()
+	state = state.MoveWordBackward()
+	assert.Equal(cursor{row: 0, col: 0}, state.cursor)
+}
+
+func TestEditorStateMoveParagraph(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("a\nb\n\nc\nd\n  \ne")
+	state = state.MoveParagraphForward()
+	assert.Equal(cursor{row: 2, col: 0}, state.cursor)
+	state = state.MoveParagraphForward()
+	assert.Equal(cursor{row: 5, col: 0}, state.cursor)
+	state = state.MoveParagraphForward()
+	assert.Equal(cursor{row: 6, col: 1}, state.cursor)
+
+	state = state.MoveParagraphBackward()
+	assert.Equal(cursor{row: 5, col: 0}, state.cursor)
+	state = state.MoveParagraphBackward()
+	assert.Equal(cursor{row: 2, col: 0}, state.cursor)
+	state = state.MoveParagraphBackward()
+	assert.Equal(cursor{row: 0, col: 0}, state.cursor)
+}
+
+func TestEditorStateGotoLine(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("a\nb\nc")
+	assert.Equal(1, state.GotoLine(2).cursor.row)
+	assert.Equal(0, state.GotoLine(-5).cursor.row)
+	assert.Equal(2, state.GotoLine(100).cursor.row)
+}
+
+func TestEditorStateDeleteForward(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("ab\ncd")
+	state = state.DeleteForward()
+	assert.Equal("b", string(state.buffer[0]))
+	state = state.MoveToEndOfLine().DeleteForward()
+	assert.Len(state.buffer, 1)
+	assert.Equal("bcd", string(state.buffer[0]))
+	state = state.MoveToEndOfBuffer().DeleteForward()
+	assert.Equal("bcd", string(state.buffer[0]))
+}
+
+func TestEditorStateDeleteWord(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("foo bar baz")
+	state = state.DeleteWordForward()
+	assert.Equal(" bar baz", string(state.buffer[0]))
+	state = state.MoveToEndOfLine().DeleteWordBackward()
+	assert.Equal(" bar ", string(state.buffer[0]))
+	assert.Equal(5, state.cursor.col)
+}
+
+func TestEditorStateKillWholeLine(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("a\nb\nc")
+	state = state.MoveDown().KillWholeLine()
+	assert.Len(state.buffer, 2)
+	assert.Equal("c", string(state.buffer[1]))
+	state = state.KillWholeLine()
+	assert.Len(state.buffer, 1)
+	assert.Equal(0, state.cursor.row)
+	state = state.KillWholeLine()
+	assert.Len(state.buffer, 1)
+	assert.Len(state.buffer[0], 0)
+}
+
+func TestEditorStateNewlineKeepsIndent(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("\t\tfoo")
+	state = state.MoveToEndOfLine().Newline()
+	assert.Equal("\t\t", string(state.buffer[1]))
+	assert.Equal(cursor{row: 1, col: 2}, state.cursor)
+}
+
+func TestEditorStateNewlineIndentsAfterBrace(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("\tif ok {}")
+	state.settings = settingsForPath("main.go")
+	state = state.MoveToEndOfLine().MoveLeft().Newline()
+	assert.Len(state.buffer, 2)
+	assert.Equal("\tif ok {", string(state.buffer[0]))
+	assert.Equal("\t}", string(state.buffer[1]))
+	assert.Equal(cursor{row: 1, col: 1}, state.cursor)
+
+	state = state.MoveUp().MoveToEndOfLine().Newline()
+	assert.Equal("\t\t", string(state.buffer[1]))
+}
+
+func TestEditorStateNewlineIndentsAfterColon(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("if ok:")
+	state.settings = settingsForPath("main.py")
+	state = state.MoveToEndOfLine().Newline()
+	assert.Equal("    ", string(state.buffer[1]))
+
+	state.settings = settingsForPath("main.go")
+	state = state.MoveUp().MoveToEndOfLine().Newline()
+	assert.Equal("", string(state.buffer[1]))
+}
+
+func TestEditorStateWriteDedentsClosingBrace(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("\t\t")
+	state.settings = settingsForPath("main.go")
+	state = state.MoveToEndOfLine().Write('}')
+	assert.Equal("\t}", string(state.buffer[0]))
+	assert.Equal(2, state.cursor.col)
+
+	state = state.Write('}')
+	assert.Equal("\t}}", string(state.buffer[0]))
+}
+
+func TestEditorStateFormat(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("package main\nfunc main(){\nx:=1\n  _ =  x\n}\n")
+	state.cursor = cursor{row: 3, col: 7}
+	state = state.Format()
+	assert.Empty(state.message)
+	assert.Equal("package main\n\nfunc main() {\n\tx := 1\n\t_ = x\n}\n", string(state.buffer.Bytes()))
+	assert.Equal(cursor{row: 4, col: 5}, state.cursor)
+}
+
+func TestEditorStateFormatSyntaxError(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("package main\nfunc main() {\n\tx := \n")
+	formatted := state.Format()
+	assert.Equal("package main\nfunc main() {\n\tx := \n", string(formatted.buffer.Bytes()))
+	assert.Contains(formatted.message, "line 3, column 8")
+}
+
+func TestProcessKeyUndo(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("ab")
+	state, _ = processKey(key{b: 'x'}, state)
+	state, _ = processKey(key{b: 'y'}, state)
+	state, _ = processKey(key{special: keyRight}, state)
+	assert.Equal("xyab", string(state.buffer[0]))
+
+	state, _ = processKey(key{b: ANSI.us}, state)
+	assert.Equal("xab", string(state.buffer[0]))
+	assert.Equal(1, state.cursor.col)
+	state, _ = processKey(key{b: ANSI.us}, state)
+	assert.Equal("ab", string(state.buffer[0]))
+	state, _ = processKey(key{b: ANSI.us}, state)
+	assert.Equal("nothing to undo", state.message)
+}
+
+func TestEditorStateBracketAtCursor(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("func() {\n\ts := \"}\" // )\n\tx[0] = '{'\n}")
+	state.settings = settingsForPath("main.go")
+
+	state.cursor = cursor{row: 0, col: 7}
+	at, match, matched, ok := state.BracketAtCursor()
+	assert.True(ok)
+	assert.True(matched)
+	assert.Equal(cursor{row: 0, col: 7}, at)
+	assert.Equal(cursor{row: 3, col: 0}, match)
+
+	state.cursor = cursor{row: 2, col: 5} // after the ]
+	at, match, matched, ok = state.BracketAtCursor()
+	assert.True(ok)
+	assert.True(matched)
+	assert.Equal(cursor{row: 2, col: 4}, at)
+	assert.Equal(cursor{row: 2, col: 2}, match)
+
+	state.cursor = cursor{row: 1, col: 7} // inside the string
+	_, _, _, ok = state.BracketAtCursor()
+	assert.False(ok)
+
+	state = state.MoveToEndOfBuffer().JumpToMatchingBracket()
+	assert.Equal(cursor{row: 0, col: 7}, state.cursor)
+}
+
+func TestEditorStateBracketUnbalanced(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("(]")
+	state.settings = settingsForPath("main.go")
+	_, _, matched, ok := state.BracketAtCursor()
+	assert.True(ok)
+	assert.False(matched)
+	state = state.JumpToMatchingBracket()
+	assert.Equal("unbalanced bracket", state.message)
+}
+
+func TestBracketsQuotesByLanguage(t *testing.T) {
+	assert := assert.New(t)
+
+	unbalanced := func(path, text string) bool {
+		for _, b := range bufferFromBytes([]byte(text)).Brackets(languageForPath(path)) {
+			if b.match < 0 {
+				return true
+			}
+		}
+		return false
+	}
+	// template strings span rows in javascript, backticks are only bytes in c.
+	assert.False(unbalanced("app.js", "f(`\n)`)"))
+	assert.True(unbalanced("main.c", "f(`\n)`)"))
+	assert.False(unbalanced("main.c", "f(')')"))
+	// a lifetime isn't the start of a string in rust.
+	assert.False(unbalanced("main.rs", "fn f<'a>(x: &'a str) {\n}"))
+	assert.True(unbalanced("main.c", "fn f<'a>(x: &'a str) {\n}"))
+}
+
+func typeNotation(state editorState, notation string) editorState {
+	keys, err := parseKeys(notation)
+	if err != nil {
+		panic(err)
+	}
+	for _, k := range keys {
+		state, _ = processKey(k, state)
+	}
+	return state
+}
+
+func TestProcessKeyPrefixArg(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("abcdefghij\n1\n2\n3\n4\n5")
+	state = typeNotation(state, `C-u C-f`)
+	assert.Equal(4, state.cursor.col)
+	state = typeNotation(state, `M-3 C-d`)
+	assert.Equal("abcdhij", string(state.buffer[0]))
+	state = typeNotation(state, `C-u 1 2 x`)
+	assert.Equal("abcdxxxxxxxxxxxxhij", string(state.buffer[0]))
+	state = typeNotation(state, `C-u C-u C-n`)
+	assert.Equal(5, state.cursor.row)
+
+	state = typeNotation(state, `M-< C-u 2 C-k`)
+	assert.Equal("2", string(state.buffer[0]))
+	state = typeNotation(state, `C-u 3 M-g`)
+	assert.Equal(2, state.cursor.row)
+	state = typeNotation(state, `M-2 <RET>`)
+	assert.Len(state.buffer, 6)
+}
+
+func TestPrefixArgIsCapped(t *testing.T) {
+	assert := assert.New(t)
+
+	state := typeNotation(stateFromString(""), `C-u 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9 9`)
+	assert.Equal(maxCount, state.arg.Count())
+	state = typeNotation(stateFromString(""), `C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u C-u x`)
+	assert.Len(state.buffer[0], maxCount)
+}
+
+func TestStateFromStdin(t *testing.T) {
+	assert := assert.New(t)
+
+	state, err := stateFromStdin(strings.NewReader("one\ntwo\n"))
+	assert.Nil(err)
+	assert.Equal("one\ntwo\n", string(state.buffer.Bytes()))
+	assert.False(state.Modified())
+
+	state = state.Save()
+	assert.NotNil(state.prompt)
+	assert.Equal("Save as: ", state.prompt.label)
+}
+
+// assertCursorInBounds fails if the cursor is outside the buffer.
+func assertCursorInBounds(t *testing.T, state editorState) {
+	if state.cursor.row < 0 || state.cursor.row >= len(state.buffer) {
+		t.Fatalf("cursor row %d outside %d rows", state.cursor.row, len(state.buffer))
+	}
+	if row := state.buffer[state.cursor.row]; state.cursor.col < 0 || state.cursor.col > len(row) {
+		t.Fatalf("cursor column %d outside row %q", state.cursor.col, row)
+	}
+}
+
+// FuzzEditorState applies random edits and motions, checking the text against a string
+// the same edits are made to, and that earlier states are never changed.
+func FuzzEditorState(f *testing.F) {
+	f.Add("one\ntwo\n", []byte{0, 'a', 3, 0, 1, 0, 1, 0, 8, 0, 2, 0, 5, 0, 4, 0})
+	f.Add("\tindented\n\n}", []byte{12, 0, 3, 0, 0, '}', 10, 0, 6, 0, 9, 0, 2, 0, 1, 0})
+	f.Add("", []byte{1, 0, 2, 0, 5, 0, 4, 0, 3, 0, 5, 0, 13, 0, 16, 0})
+
+	f.Fuzz(func(t *testing.T, text string, ops []byte) {
+		state := stateFromString(text)
+		model := text
+
+		var history []editorState
+		var models []string
+		for ; len(ops) >= 2; ops = ops[2:] {
+			op, arg := ops[0]%19, ops[1]
+			history = append(history, state)
+			models = append(models, model)
+
+			row := state.buffer[state.cursor.row]
+			offset := state.buffer.Offset(state.cursor)
+			rowStart, rowEnd := offset-state.cursor.col, offset-state.cursor.col+len(row)
+			switch op {
+			case 0:
+				if arg < ' ' || arg >= ANSI.del {
+					arg = 'x'
+				}
+				state = state.Write(arg)
+				model = model[0:offset] + string([]byte{arg}) + model[offset:]
+			case 1:
+				state = state.Backspace()
+				if offset > 0 {
+					model = model[0:offset-1] + model[offset:]
+				}
+			case 2:
+				state = state.DeleteForward()
+				if offset < len(model) {
+					model = model[0:offset] + model[offset+1:]
+				}
+			case 3:
+				state = state.newline()
+				model = model[0:offset] + "\n" + model[offset:]
+			case 4:
+				state = state.TrimLine()
+				model = model[0:offset] + model[rowEnd:]
+			case 5:
+				switch {
+				case state.cursor.row < len(state.buffer)-1:
+					model = model[0:rowStart] + model[rowEnd+1:]
+				case state.cursor.row > 0:
+					model = model[0:rowStart-1] + model[rowEnd:]
+				default:
+					model = model[0:rowStart] + model[rowEnd:]
+				}
+				state = state.KillWholeLine()
+			case 6:
+				state = state.Paste([]byte("a\nb"))
+				model = model[0:offset] + "a\nb" + model[offset:]
+			case 7:
+				state = state.MoveLeft()
+			case 8:
+				state = state.MoveRight()
+			case 9:
+				state = state.MoveUp()
+			case 10:
+				state = state.MoveDown()
+			case 11:
+				state = state.MoveToBeginningOfLine()
+			case 12:
+				state = state.MoveToEndOfLine()
+			case 13:
+				state = state.MoveWordForward()
+			case 14:
+				state = state.MoveWordBackward()
+			case 15:
+				state = state.MoveParagraphForward()
+			case 16:
+				state = state.MoveParagraphBackward()
+			case 17:
+				state = state.MoveToBeginningOfBuffer()
+			case 18:
+				state = state.MoveToEndOfBuffer()
+			}
+
+			assertCursorInBounds(t, state)
+			if string(state.buffer.Bytes()) != model {
+				t.Fatalf("op %d: got %q, want %q", op, state.buffer.Bytes(), model)
+			}
+		}
+		for x := range history {
+			if string(history[x].buffer.Bytes()) != models[x] {
+				t.Fatalf("state %d was changed to %q from %q", x, history[x].buffer.Bytes(), models[x])
+			}
+		}
+	})
+}
+
+// FuzzProcessKey decodes random input into keys and handles them in either keymap,
+// checking the cursor stays in bounds and earlier states, including undo states, are never changed.
+func FuzzProcessKey(f *testing.F) {
+	f.Add(false, "one\ntwo\n", []byte("abc\x1b[A\x02\x7f\x0b\x1bd\x18u\x1f\x19"))
+	f.Add(true, "one\ntwo\n", []byte("dwPxu\x1bVjd3jyyp:2\r"))
+	f.Add(false, "func main() {\n}\n", []byte("\x18(\x1bf\x18)\x15\x33\x18e\x1b[200~x\ny\x1b[201~\x1b[<0;3;1M"))
+	f.Add(false, "one\n", []byte("\x1b[<0;0;0M\x1b[<32;-3;1M"))
+	f.Add(true, "one\ntwo\nthree\n", []byte("\x1b[<0;1;3Mgg\x1bk\x1bk\x1b[<32;2;1Md"))
+
+	f.Add(true, "0", []byte("11111111111111U111111111111XX111"))
+
+	// naming macros writes a file, keep it out of the way.
+	dir := f.TempDir()
+	f.Setenv("HOME", dir)
+	f.Setenv("XDG_CONFIG_HOME", dir)
+
+	f.Fuzz(func(t *testing.T, vi bool, text string, input []byte) {
+		state := stateFromString(text).Resize(20, 6)
+		state.vi.enabled = vi
+		// saving, locking and finding files work in a directory of their own, rather than
+		// the working directory, so the fuzzer can still write the inputs that fail.
+		state.path = filepath.Join(t.TempDir(), "fuzz.txt")
+
+		var history []editorState
+		var contents []string
+		keys := bufio.NewReader(bytes.NewReader(input))
+		for {
+			k, err := readKey(keys)
+			if err != nil {
+				break
+			}
+			history = append(history, state)
+			contents = append(contents, string(state.buffer.Bytes()))
+
+			state, err = processKey(k, state)
+			if err == errQuit {
+				break
+			}
+			assertCursorInBounds(t, state)
+		}
+		for x := range history {
+			if string(history[x].buffer.Bytes()) != contents[x] {
+				t.Fatalf("state %d was changed to %q from %q", x, history[x].buffer.Bytes(), contents[x])
+			}
+		}
+		for undo := state.undo; undo != nil; undo = undo.undo {
+			assertCursorInBounds(t, *undo)
+		}
+	})
+}
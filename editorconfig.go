@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// editorConfigName is the name of the files holding the settings for the files in their directory and below.
+const editorConfigName = ".editorconfig"
+
+// editorConfig is the properties from .editorconfig files that apply to a file, keyed by the lower case name.
+type editorConfig map[string]string
+
+// editorConfigFile is a parsed .editorconfig file.
+type editorConfigFile struct {
+	// root stops the search for files in the directories above.
+	root     bool
+	sections []editorConfigSection
+}
+
+// editorConfigSection is the properties under a `[glob]` header.
+type editorConfigSection struct {
+	glob       string
+	properties editorConfig
+}
+
+// parseEditorConfig reads an .editorconfig file. Lines that can't be parsed are skipped,
+// like other editors do, so one bad line doesn't lose the rest of the settings.
+func parseEditorConfig(r io.Reader) (editorConfigFile, error) {
+	var file editorConfigFile
+	preamble := editorConfig{}
+	properties := preamble
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || line[0] == '#' || line[0] == ';':
+		case line[0] == '[' && line[len(line)-1] == ']':
+			properties = editorConfig{}
+			file.sections = append(file.sections, editorConfigSection{glob: line[1 : len(line)-1], properties: properties})
+		default:
+			name, value, ok := strings.Cut(line, "=")
+			if !ok {
+				continue
+			}
+			// the values of all the properties the editor knows are case insensitive.
+			properties[strings.ToLower(strings.TrimSpace(name))] = strings.ToLower(strings.TrimSpace(value))
+		}
+	}
+	file.root = preamble["root"] == "true"
+	return file, scanner.Err()
+}
+
+// loadEditorConfig finds the .editorconfig files in the directories from a file's up to the
+// first with `root = true`, returning the properties of the sections that match the file.
+// Sections in files nearer the file, and later in the same file, take precedence.
+func loadEditorConfig(path string) (editorConfig, error) {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+
+	type found struct {
+		dir  string
+		file editorConfigFile
+	}
+	var files []found
+	for dir := filepath.Dir(path); ; dir = filepath.Dir(dir) {
+		f, err := os.Open(filepath.Join(dir, editorConfigName))
+		if err == nil {
+			file, err := parseEditorConfig(f)
+			f.Close()
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", filepath.Join(dir, editorConfigName), err)
+			}
+			files = append(files, found{dir: dir, file: file})
+			if file.root {
+				break
+			}
+		} else if !os.IsNotExist(err) {
+			return nil, err
+		}
+		if filepath.Dir(dir) == dir {
+			break
+		}
+	}
+
+	config := editorConfig{}
+	for x := len(files) - 1; x >= 0; x-- {
+		relative, err := filepath.Rel(files[x].dir, path)
+		if err != nil {
+			return nil, err
+		}
+		for _, section := range files[x].file.sections {
+			if !matchEditorConfigGlob(section.glob, filepath.ToSlash(relative)) {
+				continue
+			}
+			for name, value := range section.properties {
+				config[name] = value
+				// unset takes a property back to the editor's default.
+				if value == "unset" {
+					delete(config, name)
+				}
+			}
+		}
+	}
+	return config, nil
+}
+
+// matchEditorConfigGlob returns if a path, relative to the .editorconfig file's directory, matches a section's glob.
+// Globs without a `/` match the file's name in any directory below.
+func matchEditorConfigGlob(glob, path string) bool {
+	var gc globCompiler
+	pattern := gc.convert(strings.TrimPrefix(glob, "/"))
+	if !strings.Contains(glob, "/") {
+		pattern = "(?:.*/)?" + pattern
+	}
+	re, err := regexp.Compile("^" + pattern + "$")
+	if err != nil {
+		return false
+	}
+	match := re.FindStringSubmatchIndex(path)
+	if match == nil {
+		return false
+	}
+	// `{num1..num2}` matches any number, which is then checked against the range.
+	for x, numbers := range gc.ranges {
+		start := match[2*(x+1)]
+		if start < 0 { // in an alternative that didn't match
+			continue
+		}
+		n, err := strconv.Atoi(path[start:match[2*(x+1)+1]])
+		if err != nil || n < numbers[0] || n > numbers[1] {
+			return false
+		}
+	}
+	return true
+}
+
+// globCompiler converts an editorconfig glob to a regular expression.
+type globCompiler struct {
+	// ranges are the numbers each capturing group in the expression must be between.
+	ranges [][2]int
+}
+
+func (gc *globCompiler) convert(glob string) string {
+	var re strings.Builder
+	for x := 0; x < len(glob); x++ {
+		c := glob[x]
+		switch c {
+		case '\\':
+			if x+1 < len(glob) {
+				x++
+			}
+			re.WriteString(regexp.QuoteMeta(glob[x : x+1]))
+		case '*':
+			if x+1 < len(glob) && glob[x+1] == '*' {
+				re.WriteString(".*")
+				x++
+			} else {
+				re.WriteString("[^/]*")
+			}
+		case '?':
+			re.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[x+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			re.WriteString(globClass(glob[x+1 : x+1+end]))
+			x += end + 1
+		case '{':
+			end := matchingBrace(glob, x)
+			if end < 0 {
+				re.WriteString(`\{`)
+				continue
+			}
+			inner := glob[x+1 : end]
+			if from, to, ok := globRange(inner); ok {
+				re.WriteString(`([+-]?[0-9]+)`)
+				gc.ranges = append(gc.ranges, [2]int{from, to})
+			} else if alternatives := splitAlternatives(inner); len(alternatives) > 1 {
+				re.WriteString("(?:")
+				for y, alternative := range alternatives {
+					if y > 0 {
+						re.WriteByte('|')
+					}
+					re.WriteString(gc.convert(alternative))
+				}
+				re.WriteByte(')')
+			} else {
+				// a brace without a choice in it is matched as it's written.
+				re.WriteString(`\{`)
+				continue
+			}
+			x = end
+		default:
+			re.WriteString(regexp.QuoteMeta(glob[x : x+1]))
+		}
+	}
+	return re.String()
+}
+
+// globClass converts the inside of a `[...]` glob, where a leading `!` negates it.
+func globClass(class string) string {
+	var re strings.Builder
+	re.WriteByte('[')
+	if strings.HasPrefix(class, "!") || strings.HasPrefix(class, "^") {
+		re.WriteByte('^')
+		class = class[1:]
+	}
+	for x := 0; x < len(class); x++ {
+		if strings.IndexByte(`\[]^`, class[x]) >= 0 {
+			re.WriteByte('\\')
+		}
+		re.WriteByte(class[x])
+	}
+	re.WriteByte(']')
+	return re.String()
+}
+
+// matchingBrace returns the index of the `}` closing the `{` at start, or -1.
+func matchingBrace(glob string, start int) int {
+	depth := 0
+	for x := start; x < len(glob); x++ {
+		switch glob[x] {
+		case '\\':
+			x++
+		case '{':
+			depth++
+		case '}':
+			depth--
+			if depth == 0 {
+				return x
+			}
+		}
+	}
+	return -1
+}
+
+// splitAlternatives splits the inside of a `{a,b}` glob on the commas outside nested braces.
+func splitAlternatives(inner string) []string {
+	var alternatives []string
+	depth, start := 0, 0
+	for x := 0; x < len(inner); x++ {
+		switch inner[x] {
+		case '\\':
+			x++
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ',':
+			if depth == 0 {
+				alternatives = append(alternatives, inner[start:x])
+				start = x + 1
+			}
+		}
+	}
+	return append(alternatives, inner[start:])
+}
+
+// globRange parses the inside of a `{num1..num2}` glob.
+func globRange(inner string) (from, to int, ok bool) {
+	first, second, found := strings.Cut(inner, "..")
+	if !found {
+		return 0, 0, false
+	}
+	from, fromErr := strconv.Atoi(first)
+	to, toErr := strconv.Atoi(second)
+	if fromErr != nil || toErr != nil {
+		return 0, 0, false
+	}
+	if from > to {
+		from, to = to, from
+	}
+	return from, to, true
+}
+
+// ApplyEditorConfig sets up the buffer from the .editorconfig properties for its file.
+func (es editorState) ApplyEditorConfig(config editorConfig) editorState {
+	tabWidth, hasTabWidth := editorConfigNumber(config, "tab_width")
+	indentSize, hasIndentSize := editorConfigNumber(config, "indent_size")
+	// an indent_size of tab is the tab width, and the tab width defaults to the indent size.
+	if config["indent_size"] == "tab" && hasTabWidth {
+		indentSize, hasIndentSize = tabWidth, true
+	}
+	if !hasTabWidth && hasIndentSize {
+		tabWidth, hasTabWidth = indentSize, true
+	}
+	if hasTabWidth {
+		es.settings.tabWidth = tabWidth
+	}
+
+	spaces := es.settings.indent != "\t"
+	switch config["indent_style"] {
+	case "tab":
+		spaces = false
+	case "space":
+		spaces = true
+		if !hasIndentSize && es.settings.indent == "\t" {
+			indentSize, hasIndentSize = es.settings.tabWidth, true
+		}
+	}
+	switch {
+	case !spaces:
+		es.settings.indent = "\t"
+	case hasIndentSize:
+		es.settings.indent = strings.Repeat(" ", indentSize)
+	}
+
+	if length, ok := editorConfigNumber(config, "max_line_length"); ok {
+		es.settings.maxLineLength = length
+	}
+
+	// binary and large files are written back as they are, so nothing that rewrites bytes applies.
+	if es.hex.enabled || es.large != nil {
+		return es
+	}
+	switch config["trim_trailing_whitespace"] {
+	case "true":
+		es.settings.trimTrailingWhitespace = true
+	case "false":
+		es.settings.trimTrailingWhitespace = false
+	}
+	switch config["insert_final_newline"] {
+	case "true":
+		es.settings.finalNewline = true
+	case "false":
+		es.settings.finalNewline = false
+	}
+	// files with both line endings are left for the user to convert. the file isn't modified
+	// by the setting, it's applied again each time it's opened.
+	if !es.MixedLineEndings() {
+		switch config["end_of_line"] {
+		case "lf":
+			es.lineEnding, es.savedLineEnding = lineEndingLF, lineEndingLF
+		case "crlf":
+			es.lineEnding, es.savedLineEnding = lineEndingCRLF, lineEndingCRLF
+		}
+	}
+	if charset, ok := config["charset"]; ok {
+		if e, ok := parseEncoding(charset); ok {
+			// the file is still saved as it was if it has characters the charset can't hold.
+			if _, err := encodeText(es.buffer.Bytes(), e); err == nil {
+				es.encoding = e
+			}
+		}
+	}
+	return es
+}
+
+// editorConfigNumber returns a property that's a positive number.
+func editorConfigNumber(config editorConfig, name string) (int, bool) {
+	n, err := strconv.Atoi(config[name])
+	if err != nil || n <= 0 {
+		return 0, false
+	}
+	return n, true
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func TestMatchEditorConfigGlob(t *testing.T) {
+	assert := assert.New(t)
+
+	cases := []struct {
+		glob, path string
+		match      bool
+	}{
+		{"*", "main.go", true},
+		{"*", "cmd/main.go", true},
+		{"*.go", "cmd/main.go", true},
+		{"*.go", "main.go.orig", false},
+		{"cmd/*.go", "cmd/main.go", true},
+		{"cmd/*.go", "other/cmd/main.go", false},
+		{"/cmd/*.go", "cmd/main.go", true},
+		{"cmd/*.go", "cmd/sub/main.go", false},
+		{"cmd/**.go", "cmd/sub/main.go", true},
+		{"**/testdata/*", "a/b/testdata/x", true},
+		{"?.c", "a.c", true},
+		{"?.c", "ab.c", false},
+		{"*.{js,ts}", "app.ts", true},
+		{"*.{js,ts}", "app.go", false},
+		{"{Makefile,*.mk}", "build/rules.mk", true},
+		{"{a,{b,c}}.txt", "c.txt", true},
+		{"[abc].txt", "b.txt", true},
+		{"[!abc].txt", "b.txt", false},
+		{"[!abc].txt", "d.txt", true},
+		{"file{1..3}.txt", "file2.txt", true},
+		{"file{1..3}.txt", "file4.txt", false},
+		{"{single}.txt", "{single}.txt", true},
+		{"\\*.txt", "*.txt", true},
+		{"\\*.txt", "a.txt", false},
+		{"a.txt", "a_txt", false},
+	}
+	for _, c := range cases {
+		assert.Equal(c.match, matchEditorConfigGlob(c.glob, c.path), c.glob+" "+c.path)
+	}
+}
+
+func TestParseEditorConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	file, err := parseEditorConfig(strings.NewReader(`# top of the project
+root = true
+
+[*]
+indent_style = Space
+; a comment
+not a property
+
+[*.go]
+indent_style=tab
+`))
+	assert.Nil(err)
+	assert.True(file.root)
+	assert.Len(file.sections, 2)
+	assert.Equal("*", file.sections[0].glob)
+	assert.Equal(editorConfig{"indent_style": "space"}, file.sections[0].properties)
+	assert.Equal(editorConfig{"indent_style": "tab"}, file.sections[1].properties)
+}
+
+func TestLoadEditorConfigPrecedence(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	assert.Nil(os.MkdirAll(filepath.Join(dir, "project", "cmd"), 0755))
+	assert.Nil(os.WriteFile(filepath.Join(dir, ".editorconfig"), []byte("[*]\ncharset = latin1\n"), 0644))
+	assert.Nil(os.WriteFile(filepath.Join(dir, "project", ".editorconfig"), []byte(`root = true
+[*]
+indent_size = 2
+max_line_length = 80
+[*.go]
+indent_size = 8
+`), 0644))
+	assert.Nil(os.WriteFile(filepath.Join(dir, "project", "cmd", ".editorconfig"), []byte("[main.go]\nmax_line_length = unset\n"), 0644))
+
+	config, err := loadEditorConfig(filepath.Join(dir, "project", "cmd", "main.go"))
+	assert.Nil(err)
+	// the file above the root isn't read, and the nearest file wins.
+	assert.Equal(editorConfig{"indent_size": "8"}, config)
+
+	config, err = loadEditorConfig(filepath.Join(dir, "project", "README"))
+	assert.Nil(err)
+	assert.Equal(editorConfig{"indent_size": "2", "max_line_length": "80"}, config)
+}
+
+func TestApplyEditorConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("one\ntwo\n")
+	state.settings = settingsForPath("main.go")
+	state = state.ApplyEditorConfig(editorConfig{
+		"indent_style":             "space",
+		"indent_size":              "2",
+		"end_of_line":              "crlf",
+		"charset":                  "utf-8-bom",
+		"trim_trailing_whitespace": "true",
+		"insert_final_newline":     "true",
+		"max_line_length":          "100",
+	})
+	assert.Equal("  ", state.settings.indent)
+	assert.Equal(2, state.settings.tabWidth)
+	assert.Equal(lineEndingCRLF, state.lineEnding)
+	assert.Equal(encodingUTF8BOM, state.encoding)
+	assert.True(state.settings.trimTrailingWhitespace)
+	assert.True(state.settings.finalNewline)
+	assert.Equal(100, state.settings.maxLineLength)
+
+	state = stateFromString("one\n")
+	state.settings = settingsForPath("main.py")
+	state = state.ApplyEditorConfig(editorConfig{"indent_style": "tab", "tab_width": "8", "indent_size": "tab"})
+	assert.Equal("\t", state.settings.indent)
+	assert.Equal(8, state.settings.tabWidth)
+
+	// a charset that can't hold the text is ignored.
+	state = stateFromString("snowman ☃\n").ApplyEditorConfig(editorConfig{"charset": "latin1"})
+	assert.Equal(encodingUTF8, state.encoding)
+}
+
+func TestLoadFileAppliesEditorConfig(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	assert.Nil(os.WriteFile(filepath.Join(dir, ".editorconfig"), []byte("root = true\n[*.txt]\ntab_width = 2\nend_of_line = crlf\n"), 0644))
+	path := filepath.Join(dir, "notes.txt")
+
+	state, err := loadFile(path)
+	assert.Nil(err)
+	assert.Equal(2, state.settings.tabWidth)
+	state = typeNotation(state, `"one" <RET> "two" C-x C-s`)
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("one\r\ntwo", string(written))
+}
+
+func TestRenderMaxLineLength(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("short\na longer row\n")
+	state.settings.maxLineLength = 8
+	screen := newVirtualTerminal(20, 4)
+	render(screen, state)
+	assert.Equal("a longer row", screen.Text(1))
+	assert.Contains(screen.String(), "^        wwww\n")
+}
+
+func TestEditorConfigLeavesBinaryFilesAlone(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	assert.Nil(os.WriteFile(filepath.Join(dir, ".editorconfig"), []byte("root = true\n[*]\ntrim_trailing_whitespace = true\ninsert_final_newline = true\nend_of_line = crlf\n"), 0644))
+	path := filepath.Join(dir, "data.bin")
+	data := "\x00\x01\x02 \n\t\t\n\x00\xff"
+	assert.Nil(os.WriteFile(path, []byte(data), 0644))
+
+	state, err := loadFile(path)
+	assert.Nil(err)
+	assert.True(state.hex.enabled)
+	assert.False(state.settings.trimTrailingWhitespace)
+	assert.False(state.settings.finalNewline)
+	assert.Equal(lineEndingLF, state.lineEnding)
+
+	state = typeNotation(state, `"00" C-x C-s`)
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal(data, string(written))
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// textEncoding is the encoding of a file on disk; buffers are always edited as utf-8.
+type textEncoding int
+
+const (
+	encodingUTF8 textEncoding = iota
+	encodingUTF8BOM
+	encodingUTF16LE
+	encodingUTF16BE
+	encodingLatin1
+)
+
+var encodingNames = map[textEncoding]string{
+	encodingUTF8:    "utf-8",
+	encodingUTF8BOM: "utf-8-bom",
+	encodingUTF16LE: "utf-16le",
+	encodingUTF16BE: "utf-16be",
+	encodingLatin1:  "latin-1",
+}
+
+var (
+	bomUTF8    = []byte{0xef, 0xbb, 0xbf}
+	bomUTF16LE = []byte{0xff, 0xfe}
+	bomUTF16BE = []byte{0xfe, 0xff}
+)
+
+func (e textEncoding) String() string {
+	return encodingNames[e]
+}
+
+// parseEncoding returns the encoding with a name.
+func parseEncoding(name string) (textEncoding, bool) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	for e, encodingName := range encodingNames {
+		if name == encodingName || name == strings.Replace(encodingName, "-", "", -1) {
+			return e, true
+		}
+	}
+	return encodingUTF8, false
+}
+
+// detectEncoding guesses the encoding of a file from a byte order mark, or else from
+// whether it looks like utf-16 without a mark or is valid utf-8, falling back to latin-1.
+func detectEncoding(data []byte) textEncoding {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return encodingUTF8BOM
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return encodingUTF16LE
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return encodingUTF16BE
+	}
+
+	// ascii text in utf-16 has a nul in every other byte, which is also valid utf-8.
+	if len(data) >= 2 && len(data)%2 == 0 {
+		var evenNuls, oddNuls int
+		for x := 0; x < len(data); x += 2 {
+			if data[x] == 0 {
+				evenNuls++
+			}
+			if data[x+1] == 0 {
+				oddNuls++
+			}
+		}
+		half := len(data) / 2
+		if oddNuls > half*3/4 && evenNuls == 0 {
+			return encodingUTF16LE
+		}
+		if evenNuls > half*3/4 && oddNuls == 0 {
+			return encodingUTF16BE
+		}
+	}
+	if utf8.Valid(data) {
+		return encodingUTF8
+	}
+	return encodingLatin1
+}
+
+// decodeText converts text in an encoding to utf-8. A utf-16 file cut off part way through
+// a character ends in the replacement character.
+func decodeText(data []byte, e textEncoding) []byte {
+	switch e {
+	case encodingUTF8BOM:
+		return bytes.TrimPrefix(data, bomUTF8)
+	case encodingUTF16LE, encodingUTF16BE:
+		var order binary.ByteOrder = binary.LittleEndian
+		bom := bomUTF16LE
+		if e == encodingUTF16BE {
+			order, bom = binary.BigEndian, bomUTF16BE
+		}
+		data = bytes.TrimPrefix(data, bom)
+		units := make([]uint16, len(data)/2)
+		for x := range units {
+			units[x] = order.Uint16(data[2*x:])
+		}
+		decoded := []byte(string(utf16.Decode(units)))
+		if len(data)%2 != 0 {
+			decoded = utf8.AppendRune(decoded, utf8.RuneError)
+		}
+		return decoded
+	case encodingLatin1:
+		decoded := make([]byte, 0, len(data))
+		for _, b := range data {
+			decoded = utf8.AppendRune(decoded, rune(b))
+		}
+		return decoded
+	}
+	return data
+}
+
+// encodeText converts utf-8 text to an encoding, failing if it has characters the encoding can't hold.
+func encodeText(text []byte, e textEncoding) ([]byte, error) {
+	switch e {
+	case encodingUTF8BOM:
+		return append(append([]byte{}, bomUTF8...), text...), nil
+	case encodingUTF16LE, encodingUTF16BE:
+		var order binary.AppendByteOrder = binary.LittleEndian
+		bom := bomUTF16LE
+		if e == encodingUTF16BE {
+			order, bom = binary.BigEndian, bomUTF16BE
+		}
+		units := utf16.Encode([]rune(string(text)))
+		encoded := append(make([]byte, 0, len(bom)+2*len(units)), bom...)
+		for _, unit := range units {
+			encoded = order.AppendUint16(encoded, unit)
+		}
+		return encoded, nil
+	case encodingLatin1:
+		encoded := make([]byte, 0, len(text))
+		line, col := 1, 1
+		for _, r := range string(text) {
+			if r > 0xff {
+				return nil, fmt.Errorf("line %d, column %d: %q can't be written as %s", line, col, r, e)
+			}
+			encoded = append(encoded, byte(r))
+			if r == '\n' {
+				line, col = line+1, 1
+			} else {
+				col++
+			}
+		}
+		return encoded, nil
+	}
+	return text, nil
+}
+
+// PromptEncoding asks for the encoding the file is written in when it's saved.
+func (es editorState) PromptEncoding() editorState {
+	if es.large != nil { // large files are written back as they're read
+		es.message = "can't change the encoding of a large file"
+		return es
+	}
+	names := make([]string, 0, len(encodingNames))
+	for e := encodingUTF8; e <= encodingLatin1; e++ {
+		names = append(names, e.String())
+	}
+	label := fmt.Sprintf("Encoding (%s, now %s): ", strings.Join(names, ", "), es.encoding)
+	return es.Prompt(label, func(es editorState, name string) editorState {
+		e, ok := parseEncoding(name)
+		if !ok {
+			es.message = fmt.Sprintf("unknown encoding: %q", name)
+			return es
+		}
+		if _, err := encodeText(es.buffer.Bytes(), e); err != nil {
+			es.message = err.Error()
+			return es
+		}
+		es.encoding = e
+		es.message = fmt.Sprintf("will save as %s", e)
+		return es
+	})
+}
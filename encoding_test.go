@@ -0,0 +1,73 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func TestDetectEncoding(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal(encodingUTF8, detectEncoding([]byte("héllo\n")))
+	assert.Equal(encodingUTF8BOM, detectEncoding([]byte("\xef\xbb\xbfhello\n")))
+	assert.Equal(encodingUTF16LE, detectEncoding([]byte("\xff\xfeh\x00i\x00")))
+	assert.Equal(encodingUTF16BE, detectEncoding([]byte("\xfe\xff\x00h\x00i")))
+	assert.Equal(encodingUTF16LE, detectEncoding([]byte("h\x00i\x00\n\x00")))
+	assert.Equal(encodingLatin1, detectEncoding([]byte("h\xe9llo\n")))
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	assert := assert.New(t)
+
+	text := []byte("héllo\nwörld\n")
+	for _, e := range []textEncoding{encodingUTF8, encodingUTF8BOM, encodingUTF16LE, encodingUTF16BE, encodingLatin1} {
+		encoded, err := encodeText(text, e)
+		assert.Nil(err)
+		assert.Equal(e, detectEncoding(encoded))
+		assert.Equal(string(text), string(decodeText(encoded, e)))
+	}
+
+	_, err := encodeText([]byte("one\n€"), encodingLatin1)
+	assert.NotNil(err)
+	assert.Contains(err.Error(), "line 2, column 1")
+}
+
+func TestSaveKeepsEncoding(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "windows.txt")
+	assert.Nil(os.WriteFile(path, []byte("\xff\xfeh\x00i\x00\n\x00"), 0644))
+	state, err := loadFile(path)
+	assert.Nil(err)
+	assert.Equal(encodingUTF16LE, state.encoding)
+	assert.Equal("hi\n", string(state.buffer.Bytes()))
+
+	state = typeNotation(state, `C-e "!" C-x C-s`)
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("\xff\xfeh\x00i\x00!\x00\n\x00", string(written))
+
+	state = typeNotation(state, `C-x <RET> "utf-8" <RET> C-x C-s`)
+	assert.Equal(encodingUTF8, state.encoding)
+	written, err = os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("hi!\n", string(written))
+}
+
+func TestUTF16WithOddByteCount(t *testing.T) {
+	assert := assert.New(t)
+
+	state, err := stateFromBytes([]byte("\xff\xfeh\x00i\x00\n"))
+	assert.Nil(err)
+	assert.Equal(encodingUTF16LE, state.encoding)
+	assert.Equal("hi�", string(state.buffer.Bytes()))
+	assert.Equal(`utf-16le text ends part way through a character, which is shown and saved as '�'`, state.message)
+
+	state, err = stateFromBytes([]byte("\xfe\xff\x00h\x00"))
+	assert.Nil(err)
+	assert.Equal(encodingUTF16BE, state.encoding)
+	assert.Equal("h�", string(state.buffer.Bytes()))
+}
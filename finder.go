@@ -0,0 +1,480 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// finderRows is the most matches shown above the status line.
+	finderRows = 10
+	// finderMaxFiles stops listing huge trees, so opening the finder stays quick.
+	finderMaxFiles = 50000
+)
+
+// finder picks a file in the project to open, narrowing the list by a fuzzy match as the user types.
+type finder struct {
+	root  string
+	files []string // relative to the root, with forward slashes
+	input []byte
+	// matches are the files matching the input, best first.
+	matches  []string
+	selected int
+}
+
+// FindFile opens the finder over the files in the project of the file being edited.
+func (es editorState) FindFile() editorState {
+	dir := "."
+	if es.path != "" {
+		dir = filepath.Dir(es.path)
+	}
+	root, err := projectRoot(dir)
+	if err != nil {
+		es.message = err.Error()
+		return es
+	}
+	files, err := listProjectFiles(root)
+	if err != nil {
+		es.message = err.Error()
+		return es
+	}
+	es.finder = &finder{root: root, files: files, matches: rankFiles(files, "")}
+	return es
+}
+
+// projectRoot returns the nearest directory from dir up with a go.mod or .git, or dir itself if there isn't one.
+func projectRoot(dir string) (string, error) {
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+	for search := dir; ; search = filepath.Dir(search) {
+		for _, marker := range []string{"go.mod", ".git"} {
+			if _, err := os.Stat(filepath.Join(search, marker)); err == nil {
+				return search, nil
+			}
+		}
+		if filepath.Dir(search) == search {
+			return dir, nil
+		}
+	}
+}
+
+// listProjectFiles returns the files under root that aren't ignored by a .gitignore, relative to root.
+func listProjectFiles(root string) ([]string, error) {
+	var ignore gitignore
+	var files []string
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if path == root {
+				return err
+			}
+			return nil // skip what can't be read
+		}
+		relative, _ := filepath.Rel(root, path)
+		relative = filepath.ToSlash(relative)
+		if d.IsDir() {
+			if path == root {
+				return ignore.load(root, "")
+			}
+			if d.Name() == ".git" || ignore.Ignored(relative, true) {
+				return filepath.SkipDir
+			}
+			return ignore.load(path, relative)
+		}
+		if ignore.Ignored(relative, false) {
+			return nil
+		}
+		files = append(files, relative)
+		if len(files) >= finderMaxFiles {
+			return filepath.SkipAll
+		}
+		return nil
+	})
+	return files, err
+}
+
+// gitignore is the rules from the .gitignore files in a tree; the last rule to match a path decides.
+type gitignore struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	pattern *regexp.Regexp
+	// negate re-includes paths matched by an earlier rule.
+	negate bool
+	// dirOnly rules, ending with a `/`, only match directories.
+	dirOnly bool
+}
+
+// load adds the rules in the .gitignore in a directory, which is dir relative to the root.
+func (g *gitignore) load(path, dir string) error {
+	f, err := os.Open(filepath.Join(path, ".gitignore"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	return g.parse(f, dir)
+}
+
+// parse adds gitignore rules for the paths in dir.
+func (g *gitignore) parse(r io.Reader, dir string) error {
+	prefix := ""
+	if dir != "" {
+		prefix = regexp.QuoteMeta(dir + "/")
+	}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), " \t")
+		if line == "" || line[0] == '#' {
+			continue
+		}
+		var rule ignoreRule
+		if line[0] == '!' {
+			rule.negate = true
+			line = line[1:]
+		}
+		if strings.HasSuffix(line, "/") {
+			rule.dirOnly = true
+			line = strings.TrimRight(line, "/")
+		}
+		// patterns with a slash before the end are from the .gitignore's directory, others match at any depth.
+		anywhere := "(?:.*/)?"
+		if strings.Contains(line, "/") {
+			anywhere = ""
+			line = strings.TrimPrefix(line, "/")
+		}
+		if line == "" {
+			continue
+		}
+		pattern, err := regexp.Compile("^" + prefix + anywhere + gitignoreRegexp(line) + "$")
+		if err != nil {
+			continue
+		}
+		rule.pattern = pattern
+		g.rules = append(g.rules, rule)
+	}
+	return scanner.Err()
+}
+
+// gitignoreRegexp converts a gitignore pattern to a regular expression.
+func gitignoreRegexp(pattern string) string {
+	var re strings.Builder
+	for x := 0; x < len(pattern); x++ {
+		switch {
+		case strings.HasPrefix(pattern[x:], "**/"): // any number of directories, even none
+			re.WriteString("(?:.*/)?")
+			x += 2
+		case strings.HasPrefix(pattern[x:], "**"):
+			re.WriteString(".*")
+			x++
+		case pattern[x] == '*':
+			re.WriteString("[^/]*")
+		case pattern[x] == '?':
+			re.WriteString("[^/]")
+		case pattern[x] == '[':
+			end := strings.IndexByte(pattern[x+1:], ']')
+			if end < 0 {
+				re.WriteString(`\[`)
+				continue
+			}
+			re.WriteString(globClass(pattern[x+1 : x+1+end]))
+			x += end + 1
+		case pattern[x] == '\\' && x+1 < len(pattern):
+			x++
+			re.WriteString(regexp.QuoteMeta(pattern[x : x+1]))
+		default:
+			re.WriteString(regexp.QuoteMeta(pattern[x : x+1]))
+		}
+	}
+	return re.String()
+}
+
+// Ignored returns if a path, relative to the root, is ignored.
+func (g gitignore) Ignored(path string, isDir bool) bool {
+	var ignored bool
+	for _, rule := range g.rules {
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		if rule.pattern.MatchString(path) {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+const (
+	fuzzyMatchScore     = 1
+	fuzzyRunBonus       = 5 // the byte follows the last one matched
+	fuzzyWordStartBonus = 8 // the byte starts a directory, file name or word
+	fuzzyNameBonus      = 2 // the byte is in the file's name rather than its directory
+	fuzzyGapPenalty     = 1 // for each byte skipped between matches
+	fuzzyNoMatch        = -1 << 30
+)
+
+// fuzzyScore returns how well the bytes of pattern match a path in order, ignoring case,
+// picking the best of the ways they can match. Matches that run together or start words
+// score higher, and gaps between them lower.
+func fuzzyScore(pattern, path string) (int, bool) {
+	if pattern == "" {
+		return 0, true
+	}
+	lowerPattern, lowerPath := lowerASCII(pattern), lowerASCII(path)
+	nameStart := strings.LastIndexByte(path, '/') + 1
+	bonus := func(x int) int {
+		score := fuzzyMatchScore
+		if x == 0 || strings.IndexByte("/_-. ", path[x-1]) >= 0 || (isUpper(path[x]) && !isUpper(path[x-1])) {
+			score += fuzzyWordStartBonus
+		}
+		if x >= nameStart {
+			score += fuzzyNameBonus
+		}
+		return score
+	}
+
+	// previous[x] is the best score with the last pattern byte matched at x.
+	previous := make([]int, len(path))
+	current := make([]int, len(path))
+	for p := 0; p < len(lowerPattern); p++ {
+		before := fuzzyNoMatch // the best of previous[0:x-1], less the gaps to x
+		for x := 0; x < len(lowerPath); x++ {
+			current[x] = fuzzyNoMatch
+			if lowerPath[x] == lowerPattern[p] {
+				switch {
+				case p == 0:
+					current[x] = bonus(x)
+				case x > 0 && previous[x-1] > fuzzyNoMatch && previous[x-1]+fuzzyRunBonus > before:
+					current[x] = previous[x-1] + fuzzyRunBonus + bonus(x)
+				case before > fuzzyNoMatch:
+					current[x] = before + bonus(x)
+				}
+			}
+			if before > fuzzyNoMatch {
+				before -= fuzzyGapPenalty
+			}
+			if p > 0 && x > 0 && previous[x-1] > fuzzyNoMatch && previous[x-1]-fuzzyGapPenalty > before {
+				before = previous[x-1] - fuzzyGapPenalty
+			}
+		}
+		previous, current = current, previous
+	}
+
+	best := fuzzyNoMatch
+	for _, score := range previous {
+		if score > best {
+			best = score
+		}
+	}
+	return best, best > fuzzyNoMatch
+}
+
+func isUpper(b byte) bool {
+	return b >= 'A' && b <= 'Z'
+}
+
+// lowerASCII lower cases only ascii letters, so the bytes stay at the same offsets.
+func lowerASCII(s string) string {
+	lower := []byte(s)
+	for x, b := range lower {
+		if isUpper(b) {
+			lower[x] = b + 'a' - 'A'
+		}
+	}
+	return string(lower)
+}
+
+// rankFiles returns the files matching a pattern, best first, then shortest, then in order.
+func rankFiles(files []string, pattern string) []string {
+	type match struct {
+		path  string
+		score int
+	}
+	var matches []match
+	for _, path := range files {
+		if score, ok := fuzzyScore(pattern, path); ok {
+			matches = append(matches, match{path: path, score: score})
+		}
+	}
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].score != matches[j].score {
+			return matches[i].score > matches[j].score
+		}
+		return len(matches[i].path) < len(matches[j].path)
+	})
+	ranked := make([]string, len(matches))
+	for x, m := range matches {
+		ranked[x] = m.path
+	}
+	return ranked
+}
+
+// setInput re-ranks the files for new input, selecting the best match.
+func (f finder) setInput(input []byte) *finder {
+	f.input = input
+	f.matches = rankFiles(f.files, string(input))
+	f.selected = 0
+	return &f
+}
+
+// processFinderKey handles keys while the finder is open.
+func processFinderKey(k key, state editorState) editorState {
+	f := *state.finder
+	switch k.special {
+	case keyNone:
+	case keyUp:
+		k = key{b: ANSI.dle}
+	case keyDown:
+		k = key{b: ANSI.so}
+	case keyPaste:
+		text := bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, []byte(k.text))
+		state.finder = f.setInput(append(append([]byte{}, f.input...), text...))
+		return state
+	default:
+		return state
+	}
+	if k.meta {
+		return state
+	}
+
+	switch k.b {
+	case ANSI.bel, ANSI.etx, ANSI.esc: // cancel
+		state.finder = nil
+		state.message = "cancelled"
+	case ANSI.cr, ANSI.lf:
+		if len(f.matches) == 0 {
+			state.message = "no matching files"
+			return state
+		}
+		state.finder = nil
+		return state.OpenFile(filepath.Join(f.root, filepath.FromSlash(f.matches[f.selected])))
+	case ANSI.dle: // C-p
+		if f.selected > 0 {
+			f.selected--
+		}
+		state.finder = &f
+	case ANSI.so: // C-n
+		if f.selected < len(f.matches)-1 {
+			f.selected++
+		}
+		state.finder = &f
+	case ANSI.bs, ANSI.del:
+		if len(f.input) > 0 {
+			state.finder = f.setInput(f.input[:len(f.input)-1])
+		}
+	default:
+		if k.b >= ' ' {
+			// copy so earlier states keep their input.
+			state.finder = f.setInput(append(append([]byte{}, f.input...), k.b))
+		}
+	}
+	return state
+}
+
+// OpenFile replaces the buffer with another file, asking what to do with unsaved changes first.
+func (es editorState) OpenFile(path string) editorState {
+	if !es.Modified() {
+		return es.openFile(path)
+	}
+	label := fmt.Sprintf("%s has unsaved changes: (s)ave, (d)iscard, (c)ancel? ", es.path)
+	return es.Choose(label, "sdc", func(es editorState, choice byte) editorState {
+		switch choice {
+		case 's':
+			es = es.Save()
+			// saving may have to ask something first, or failed.
+			if es.prompt != nil || es.Modified() {
+				return es
+			}
+		case 'd':
+			es = es.RemoveRecovery()
+		default:
+			return es
+		}
+		return es.openFile(path)
+	})
+}
+
+func (es editorState) openFile(path string) editorState {
+	current, _ := filepath.Abs(es.path)
+	if opening, _ := filepath.Abs(path); es.path != "" && opening == current {
+		es.message = fmt.Sprintf("already editing %s", es.path)
+		return es
+	}
+	opened, err := loadFile(path)
+	if err != nil {
+		es.message = err.Error()
+		return es
+	}
+	es.Unlock()
+	es.closeLarge()
+
+	// the keymap, macros and clipboard carry over, the rest is the new file's.
+	opened = applyFlags(opened)
+	opened.width, opened.height = es.width, es.height
+	opened.vi.enabled = es.vi.enabled
+	opened.macro = es.macro
+	opened.macros = es.macros
+	opened.clipboard = es.clipboard
+	opened.justOpened = true
+	if opened.message == "" {
+		opened.message = fmt.Sprintf("opened %s", path)
+	}
+	return opened.CheckRecovery()
+}
+
+// renderFinder draws the best matches above the status line, with the selected one highlighted,
+// and the input in the status line.
+func renderFinder(tty io.Writer, state editorState) {
+	f := state.finder
+	rows := minInt(finderRows, len(f.matches))
+	if height := state.TextHeight(); rows > height {
+		rows = height
+	}
+	// keep the selected match in the list.
+	first := 0
+	if f.selected >= rows {
+		first = f.selected - rows + 1
+	}
+	var line []byte
+	for x := 0; x < rows; x++ {
+		tty.Write(ANSI.MoveCursor(state.height-rows+x, 0))
+		tty.Write(ANSI.clearLine)
+		// paths are drawn like text, so control bytes in them can't reach the terminal.
+		path := f.matches[first+x]
+		line = line[:0]
+		for y := 0; y < len(path); y++ {
+			if state.width > 0 && len(line)+glyphWidth(path[y], state.settings.tabWidth) > state.width {
+				break
+			}
+			line = appendGlyph(line, path[y], state.settings.tabWidth)
+		}
+		if first+x == f.selected {
+			tty.Write(ANSI.colorReverse)
+			tty.Write(line)
+			tty.Write(ANSI.colorReset)
+		} else {
+			tty.Write(line)
+		}
+	}
+
+	status := fmt.Sprintf("Find file (%d/%d): %s", len(f.matches), len(f.files), f.input)
+	tty.Write(ANSI.MoveCursor(state.height, 0))
+	tty.Write([]byte(status))
+	tty.Write(ANSI.MoveCursor(state.height, len(status)+1))
+}
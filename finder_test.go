@@ -0,0 +1,185 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+// writeFiles writes files with their contents under dir, making the directories they're in.
+func writeFiles(t *testing.T, dir string, files map[string]string) {
+	for name, contents := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(contents), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestProjectRoot(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{"go.mod": "module example\n", "cmd/tool/main.go": ""})
+	root, err := projectRoot(filepath.Join(dir, "cmd", "tool"))
+	assert.Nil(err)
+	assert.Equal(dir, root)
+}
+
+func TestListProjectFilesHonorsGitignore(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		".gitignore":           "# build output\n*.log\n!keep.log\nbuild/\n/top.txt\ndocs/**/draft.md\n",
+		".git/HEAD":            "ref: refs/heads/main\n",
+		"main.go":              "",
+		"debug.log":            "",
+		"keep.log":             "",
+		"top.txt":              "",
+		"build/out":            "",
+		"sub/top.txt":          "",
+		"sub/.gitignore":       "local.txt\n",
+		"sub/local.txt":        "",
+		"sub/deeper/local.txt": "",
+		"local.txt":            "",
+		"docs/draft.md":        "",
+		"docs/a/b/draft.md":    "",
+		"docs/readme.md":       "",
+	})
+
+	files, err := listProjectFiles(dir)
+	assert.Nil(err)
+	assert.Equal([]string{".gitignore", "docs/readme.md", "keep.log", "local.txt", "main.go", "sub/.gitignore", "sub/top.txt"}, files)
+}
+
+func TestFuzzyScore(t *testing.T) {
+	assert := assert.New(t)
+
+	_, ok := fuzzyScore("mgo", "cmd/main.go")
+	assert.True(ok)
+	_, ok = fuzzyScore("gom", "cmd/main.go")
+	assert.False(ok)
+
+	// a run of bytes scores higher than the same bytes spread out.
+	run, _ := fuzzyScore("main", "main.go")
+	spread, _ := fuzzyScore("main", "my_app/init.go")
+	assert.True(run > spread)
+
+	// the best way to match is found, not the first.
+	first, _ := fuzzyScore("main", "cmd/main.go")
+	assert.True(first > spread)
+}
+
+func TestRankFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	files := []string{"internal/domain/main_test.go", "README.md", "cmd/main.go", "machine.go"}
+	assert.Equal([]string{"cmd/main.go", "internal/domain/main_test.go", "machine.go"}, rankFiles(files, "main"))
+	assert.Equal([]string{"README.md"}, rankFiles(files, "READ"))
+	assert.Equal([]string{"README.md", "machine.go", "cmd/main.go", "internal/domain/main_test.go"}, rankFiles(files, ""))
+}
+
+func TestFindFileOpensSelected(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{
+		"go.mod":     "module example\n",
+		"a/main.go":  "package a\n",
+		"b/main.go":  "package b\n",
+		"b/other.go": "package b\n",
+	})
+	state, err := loadFile(filepath.Join(dir, "a", "main.go"))
+	assert.Nil(err)
+	state.macros = map[string][]key{"m": {{b: 'x'}}}
+
+	state = typeNotation(state, `C-x C-f "main"`)
+	assert.NotNil(state.finder)
+	assert.Equal([]string{"a/main.go", "b/main.go"}, state.finder.matches)
+	state = typeNotation(state, `C-n <RET>`)
+	assert.Nil(state.finder)
+	assert.Equal(filepath.Join(dir, "b", "main.go"), state.path)
+	assert.Equal("package b\n", string(state.buffer.Bytes()))
+	assert.Len(state.macros, 1)
+
+	// the other file's history isn't undone into this one.
+	assert.Equal("nothing to undo", typeNotation(state, `C-_`).message)
+
+	state = typeNotation(state, `C-x C-f "zzz" <RET>`)
+	assert.Equal("no matching files", state.message)
+	state = typeNotation(state, `C-g`)
+	assert.Nil(state.finder)
+}
+
+func TestFindFileAsksAboutUnsavedChanges(t *testing.T) {
+	assert := assert.New(t)
+
+	dir := t.TempDir()
+	writeFiles(t, dir, map[string]string{".git/HEAD": "", "one.txt": "one\n", "two.txt": "two\n"})
+	state, err := loadFile(filepath.Join(dir, "one.txt"))
+	assert.Nil(err)
+
+	state = typeNotation(state, `"edited " C-x C-f "two" <RET>`)
+	assert.NotNil(state.prompt)
+	assert.True(strings.Contains(state.prompt.label, "unsaved changes"))
+	cancelled := typeNotation(state, `c`)
+	assert.Equal(filepath.Join(dir, "one.txt"), cancelled.path)
+	assert.True(cancelled.Modified())
+
+	state = typeNotation(state, `s`)
+	assert.Equal(filepath.Join(dir, "two.txt"), state.path)
+	written, err := os.ReadFile(filepath.Join(dir, "one.txt"))
+	assert.Nil(err)
+	assert.Equal("edited one\n", string(written))
+	_, err = os.Lstat(lockPath(filepath.Join(dir, "one.txt")))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestRenderFinder(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("text\n").Resize(30, 5)
+	state.finder = (&finder{files: []string{"a.go", "b.go", "c.go", "d.go", "e.txt"}}).setInput([]byte("go"))
+	state.finder.selected = 3
+	screen := renderScreen(state)
+	// three rows fit above the status line, scrolled to keep the selection in them.
+	assert.Equal("b.go", screen.Text(1))
+	assert.Equal("d.go", screen.Text(3))
+	assert.Equal("Find file (4/5): go", screen.Text(4))
+	assert.Contains(screen.String(), "|d.go\n^rrrr\n")
+	assert.Contains(screen.String(), "cursor 5:20\n")
+}
+
+func TestSaveAsIsntOpeningAFile(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "new.txt")
+	state := newEditorState()
+	state.settings.trimTrailingWhitespace = true
+	state = typeNotation(state, `"a  " C-x C-s "`+path+`" <RET>`)
+	assert.Equal(path, state.path)
+	assert.Equal("a", string(state.buffer.Bytes()))
+	assert.False(state.Modified())
+
+	// the clean up is a change like any other, so it can be undone.
+	state = typeNotation(state, `C-_`)
+	assert.Equal("a  ", string(state.buffer.Bytes()))
+	assert.True(state.Modified())
+}
+
+func TestRenderFinderEscapesControlBytes(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("text\n").Resize(20, 3)
+	state.finder = (&finder{files: []string{"bad\x1b[2Jname\tis a long one.go"}}).setInput(nil)
+	screen := renderScreen(state)
+	// cut off at the screen's width, counting the carets.
+	assert.Equal("bad^[[2Jname    is a", screen.Text(1))
+}
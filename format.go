@@ -0,0 +1,53 @@
+package main
+
+import (
+	"fmt"
+	"go/format"
+	"go/scanner"
+)
+
+// Format runs the buffer through gofmt, keeping the cursor on the same token.
+// If the buffer doesn't parse the text is left alone and the error is shown.
+func (es editorState) Format() editorState {
+	if es.large != nil { // gofmt needs the whole file
+		es.message = "can't format a large file"
+		return es
+	}
+	source := es.buffer.Bytes()
+	formatted, err := format.Source(source)
+	if err != nil {
+		es.message = formatError(err)
+		return es
+	}
+
+	// gofmt only moves whitespace around, so the cursor stays on its token
+	// if it stays after the same number of non-blank bytes.
+	offset := es.buffer.Offset(es.cursor)
+	tokenBytes := countNonBlank(source[0:offset])
+	onToken := offset < len(source) && !isBlank(source[offset]) && source[offset] != byteNewLine
+
+	es.buffer = bufferFromBytes(formatted)
+	es.cursor = es.buffer.CursorAtNonBlank(tokenBytes)
+	if onToken { // the whitespace before the token may have changed
+		for es.cursor.col < len(es.buffer[es.cursor.row]) && isBlank(es.buffer[es.cursor.row][es.cursor.col]) {
+			es.cursor.col++
+		}
+	}
+	return es
+}
+
+func formatError(err error) string {
+	if list, ok := err.(scanner.ErrorList); ok && len(list) > 0 {
+		return fmt.Sprintf("gofmt: line %d, column %d: %s", list[0].Pos.Line, list[0].Pos.Column, list[0].Msg)
+	}
+	return fmt.Sprintf("gofmt: %v", err)
+}
+
+func countNonBlank(text []byte) (count int) {
+	for _, b := range text {
+		if !isBlank(b) && b != byteNewLine {
+			count++
+		}
+	}
+	return
+}
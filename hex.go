@@ -0,0 +1,308 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+const (
+	// hexRowBytes is how many bytes are shown on each row in hex mode.
+	hexRowBytes = 16
+	// hexSniffBytes is how much of a file is looked at to tell if it's binary.
+	hexSniffBytes = 8000
+	// hexColumn is the screen column of the first byte's hex digits, after the offset.
+	hexColumn = 10
+)
+
+// hexState is the state of hex mode, where the bytes of the buffer are shown and edited as hex.
+type hexState struct {
+	enabled bool
+	// low is set when the cursor is on the low nibble of the byte.
+	low bool
+	// index is where the rows of the buffer start, so moving by offsets doesn't walk every row.
+	index *hexIndex
+}
+
+// hexIndex is the offset each row of a buffer starts at.
+type hexIndex struct {
+	buffer buffer
+	starts []int
+}
+
+// isBinary returns if data looks like a binary file rather than text, i.e. it has a nul near
+// the start, or more than one in eight of its bytes are control characters that text doesn't use.
+func isBinary(data []byte) bool {
+	if len(data) > hexSniffBytes {
+		data = data[0:hexSniffBytes]
+	}
+	var controls int
+	for _, b := range data {
+		switch {
+		case b == 0:
+			return true
+		case b < ' ' && b != '\t' && b != '\n' && b != '\r' && b != '\f' && b != ANSI.esc:
+			controls++
+		}
+	}
+	return controls > len(data)/8
+}
+
+// ToggleHex switches between editing the buffer as text and as hex.
+func (es editorState) ToggleHex() editorState {
+	if es.large != nil && !es.hex.enabled { // the buffer is only a window of the file
+		es.message = "can't show a large file as hex"
+		return es
+	}
+	es.hex = hexState{enabled: !es.hex.enabled}
+	es.selecting = false
+	if es.hex.enabled {
+		es = es.indexHex()
+		es.cursor = es.hexCursorAt(es.hexOffset(es.cursor))
+		es.message = "hex mode"
+	}
+	es.scroll = 0
+	return es.ScrollToCursor()
+}
+
+// indexHex indexes where the rows of the buffer start, if it's changed since it was last indexed.
+func (es editorState) indexHex() editorState {
+	if index := es.hex.index; index == nil || !index.buffer.Same(es.buffer) {
+		es.hex.index = &hexIndex{buffer: es.buffer, starts: es.hexStarts()}
+	}
+	return es
+}
+
+// hexStarts returns the offset each row of the buffer starts at, from the index if it's up to date.
+func (es editorState) hexStarts() []int {
+	if index := es.hex.index; index != nil && index.buffer.Same(es.buffer) {
+		return index.starts
+	}
+	starts := make([]int, len(es.buffer))
+	for y := 1; y < len(es.buffer); y++ {
+		starts[y] = starts[y-1] + len(es.buffer[y-1]) + 1
+	}
+	return starts
+}
+
+// hexOffset returns the offset of a position in the buffer.
+func (es editorState) hexOffset(at cursor) int {
+	return es.hexStarts()[at.row] + at.col
+}
+
+// hexSize returns the number of bytes in the buffer.
+func (es editorState) hexSize() int {
+	last := len(es.buffer) - 1
+	return es.hexOffset(cursor{row: last, col: len(es.buffer[last])})
+}
+
+// hexCursorAt returns the position of the byte at an offset, kept on a byte of the buffer.
+func (es editorState) hexCursorAt(offset int) cursor {
+	if size := es.hexSize(); offset > size-1 {
+		offset = size - 1
+	}
+	if offset < 0 { // an empty buffer
+		offset = 0
+	}
+	starts := es.hexStarts()
+	row := sort.Search(len(starts), func(y int) bool { return starts[y] > offset }) - 1
+	return cursor{row: row, col: offset - starts[row]}
+}
+
+// hexBytes returns up to count bytes of the buffer from an offset.
+func (es editorState) hexBytes(offset, count int) []byte {
+	data := make([]byte, 0, count)
+	if offset >= es.hexSize() {
+		return data
+	}
+	at := es.hexCursorAt(offset)
+	for y := at.row; y < len(es.buffer) && len(data) < count; y++ {
+		row := es.buffer[y][at.col:]
+		at.col = 0
+		data = append(data, row[0:minInt(len(row), count-len(data))]...)
+		if len(data) < count && y < len(es.buffer)-1 {
+			data = append(data, byteNewLine)
+		}
+	}
+	return data
+}
+
+// hexRow returns the row of the screen the cursor is on in hex mode, before scrolling.
+func (es editorState) hexRow() int {
+	return es.hexOffset(es.cursor) / hexRowBytes
+}
+
+// HexMove moves the cursor a number of bytes.
+func (es editorState) HexMove(count int) editorState {
+	offset := es.hexOffset(es.cursor) + count
+	if offset < 0 {
+		offset = 0
+	}
+	es.cursor = es.hexCursorAt(offset)
+	es.hex.low = false
+	return es
+}
+
+// HexType sets the nibble under the cursor to a hex digit, then moves to the next nibble.
+func (es editorState) HexType(digit byte) editorState {
+	offset := es.hexOffset(es.cursor)
+	if offset >= es.hexSize() {
+		es.message = "nothing to edit at the end of the buffer"
+		return es
+	}
+
+	value := hexDigitValue(digit)
+	old := es.buffer.ByteAt(es.cursor)
+	updated := old&0x0f | value<<4
+	if es.hex.low {
+		updated = old&0xf0 | value
+	}
+	index := es.hex.index
+	es.buffer = es.buffer.ReplaceByte(es.cursor, updated)
+	if index != nil && old != byteNewLine && updated != byteNewLine {
+		// the rows start where they did.
+		es.hex.index = &hexIndex{buffer: es.buffer, starts: index.starts}
+	} else {
+		// replacing or making a newline moves the rows around the byte.
+		es = es.indexHex()
+		es.cursor = es.hexCursorAt(offset)
+	}
+
+	if es.hex.low {
+		return es.HexMove(1)
+	}
+	es.hex.low = true
+	return es
+}
+
+func isHexDigit(b byte) bool {
+	return (b >= '0' && b <= '9') || (b >= 'a' && b <= 'f') || (b >= 'A' && b <= 'F')
+}
+
+func hexDigitValue(b byte) byte {
+	switch {
+	case b >= 'a':
+		return b - 'a' + 10
+	case b >= 'A':
+		return b - 'A' + 10
+	}
+	return b - '0'
+}
+
+// processHexKey handles keys in hex mode; commands with control and meta keys work as usual.
+func processHexKey(k key, state editorState) (editorState, error) {
+	state = state.indexHex()
+	if state.prefix != 0 || state.arg.active {
+		return processEmacsKey(k, state)
+	}
+	switch k.special {
+	case keyNone:
+	case keyLeft:
+		return state.HexMove(-1), nil
+	case keyRight:
+		return state.HexMove(1), nil
+	case keyUp:
+		return state.HexMove(-hexRowBytes), nil
+	case keyDown:
+		return state.HexMove(hexRowBytes), nil
+	case keyPageUp:
+		return state.HexMove(-hexRowBytes * state.TextHeight()), nil
+	case keyPageDown:
+		return state.HexMove(hexRowBytes * state.TextHeight()), nil
+	case keyHome:
+		return state.HexMove(-(state.hexOffset(state.cursor) % hexRowBytes)), nil
+	case keyEnd:
+		return state.HexMove(hexRowBytes - 1 - state.hexOffset(state.cursor)%hexRowBytes), nil
+	case keyPaste:
+		state.message = "can't paste in hex mode"
+		return state, nil
+	default: // the mouse clicks on text positions
+		return state, nil
+	}
+
+	switch {
+	case k.meta:
+		switch k.b {
+		case '<':
+			return state.HexMove(-state.hexSize()), nil
+		case '>':
+			return state.HexMove(state.hexSize()), nil
+		}
+		return processEmacsKey(k, state)
+	case isHexDigit(k.b):
+		return state.HexType(k.b), nil
+	case k.b == ANSI.stx: // C-b
+		return state.HexMove(-1), nil
+	case k.b == ANSI.ack: // C-f
+		return state.HexMove(1), nil
+	case k.b == ANSI.dle: // C-p
+		return state.HexMove(-hexRowBytes), nil
+	case k.b == ANSI.so: // C-n
+		return state.HexMove(hexRowBytes), nil
+	case k.b < ' ':
+		return processEmacsKey(k, state)
+	}
+	state.message = "type hex digits to change bytes"
+	return state, nil
+}
+
+// renderHex draws the buffer as rows of an offset, the bytes in hex, then the bytes as text.
+// Only the bytes on screen are read.
+func renderHex(tty io.Writer, state editorState) {
+	state = state.indexHex()
+	size := state.hexSize()
+	cursorOffset := state.hexOffset(state.cursor)
+
+	lastRow := (size + hexRowBytes - 1) / hexRowBytes
+	if height := state.TextHeight(); height > 0 && state.scroll+height < lastRow {
+		lastRow = state.scroll + height
+	}
+	// data holds the bytes on screen, from the offset of the first row.
+	first := state.scroll * hexRowBytes
+	data := state.hexBytes(first, (lastRow-state.scroll)*hexRowBytes)
+	for row := state.scroll; row < lastRow; row++ {
+		tty.Write(ANSI.MoveCursor(row-state.scroll+1, 0))
+		start := row * hexRowBytes
+		end := minInt(start+hexRowBytes, size)
+
+		fmt.Fprintf(tty, "%08x  ", start)
+		for x := start; x < start+hexRowBytes; x++ {
+			switch {
+			case x >= end:
+				tty.Write([]byte("   "))
+			case x == cursorOffset:
+				tty.Write(ANSI.colorReverse)
+				fmt.Fprintf(tty, "%02x", data[x-first])
+				tty.Write(ANSI.colorReset)
+				tty.Write([]byte{' '})
+			default:
+				fmt.Fprintf(tty, "%02x ", data[x-first])
+			}
+			if x == start+hexRowBytes/2-1 {
+				tty.Write([]byte{' '})
+			}
+		}
+
+		text := make([]byte, 0, hexRowBytes)
+		for _, b := range data[start-first : end-first] {
+			if b < ' ' || b >= ANSI.del {
+				b = '.'
+			}
+			text = append(text, b)
+		}
+		fmt.Fprintf(tty, " |%s|", text)
+	}
+}
+
+// hexScreenCursor returns the screen position of the nibble under the cursor, counted from one.
+func (es editorState) hexScreenCursor() (row, col int) {
+	offset := es.hexOffset(es.cursor)
+	col = hexColumn + 3*(offset%hexRowBytes) + 1
+	if offset%hexRowBytes >= hexRowBytes/2 {
+		col++
+	}
+	if es.hex.low {
+		col++
+	}
+	return offset/hexRowBytes - es.scroll + 1, col
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func TestHexEditing(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "data.bin")
+	assert.Nil(os.WriteFile(path, []byte("\x00\x01\nab\xff"), 0644))
+	state, err := loadFile(path)
+	assert.Nil(err)
+	assert.True(state.hex.enabled)
+
+	// the nibbles are typed over in place, and newlines are bytes like any other.
+	state = typeNotation(state, `"41" <Right> "0" "a" "2"`)
+	assert.Equal("A\x01\n!b\xff", string(state.buffer.Bytes()))
+	state = typeNotation(state, `<Left> <Left> "0a"`)
+	assert.Equal("A\n\n!b\xff", string(state.buffer.Bytes()))
+	assert.Equal(cursor{row: 1, col: 0}, state.cursor)
+
+	state = typeNotation(state, `"zz"`)
+	assert.Equal("type hex digits to change bytes", state.message)
+
+	state = typeNotation(state, `C-x C-s`)
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("A\n\n!b\xff", string(written))
+
+	state = typeNotation(state, `C-_`)
+	assert.Equal("A\x01\n!b\xff", string(state.buffer.Bytes()))
+	assert.True(state.hex.enabled)
+}
+
+func TestRenderHex(t *testing.T) {
+	assert := assert.New(t)
+
+	state, err := stateFromBytes([]byte("\x00hello, world\x1b[2J\n"))
+	assert.Nil(err)
+	state = typeNotation(state.Resize(80, 4), `<Right> "6"`)
+
+	vt := renderScreen(state)
+	assert.Equal("00000000  00 68 65 6c 6c 6f 2c 20  77 6f 72 6c 64 1b 5b 32  |.hello, world.[2|", vt.Text(0))
+	assert.Equal("00000010  4a 0a                                             |J.|", vt.Text(1))
+	assert.Equal(0, vt.row)
+	assert.Equal(14, vt.col)
+}
+
+func TestIsBinary(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.True(isBinary([]byte("text\x00")))
+	assert.True(isBinary([]byte("\x01\x02\x03\x04 header \x05\x06\x07\x08")))
+	assert.False(isBinary([]byte("\tindented\r\n\x1b[1mbold\x1b[0m\f\n")))
+	assert.False(isBinary([]byte("caf\xe9 latin1\n")))
+}
+
+func TestHexIndex(t *testing.T) {
+	assert := assert.New(t)
+
+	state, err := stateFromBytes([]byte("\x00one\ntwo\n\nthree"))
+	assert.Nil(err)
+	state = state.indexHex()
+	assert.Equal([]int{0, 5, 9, 10}, state.hex.index.starts)
+	assert.Equal(cursor{row: 1, col: 3}, state.hexCursorAt(8))
+	assert.Equal(cursor{row: 3, col: 0}, state.hexCursorAt(10))
+	assert.Equal(cursor{row: 3, col: 4}, state.hexCursorAt(100))
+	assert.Equal("e\ntwo\n\nth", string(state.hexBytes(3, 9)))
+
+	// typing over a byte keeps the rows, and only copies the row it's in.
+	index := state.hex.index
+	state.cursor = cursor{row: 1}
+	edited := typeNotation(state, `"54"`)
+	assert.Equal("\x00one\nTwo\n\nthree", string(edited.buffer.Bytes()))
+	assert.True(&index.starts[0] == &edited.hex.index.starts[0])
+	assert.True(&state.buffer[0][0] == &edited.buffer[0][0])
+	assert.Equal("two", string(state.buffer[1]))
+
+	// making a newline moves the rows after it.
+	edited = typeNotation(edited, `"0a"`)
+	assert.Equal([]int{0, 5, 7, 9, 10}, edited.hexStarts())
+	assert.Equal(cursor{row: 2, col: 0}, edited.cursor)
+}
+
+func TestLargeBinaryFileIsntHex(t *testing.T) {
+	assert := assert.New(t)
+
+	// a binary without newlines, bigger than the window.
+	state, _ := mapText(t, bytes.Repeat([]byte("\x7fELF\x02\x01\x01\x00"), largeWindowBytes/4))
+	assert.False(state.hex.enabled)
+	assert.Equal("binary file, too large to show as hex", state.message)
+	assert.Len(state.buffer, 1)
+	assert.Len(state.buffer[0], largeWindowBytes)
+
+	state = typeNotation(state, `M-x "hex-mode" <RET>`)
+	assert.False(state.hex.enabled)
+	assert.Equal("can't show a large file as hex", state.message)
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+)
+
+type specialKey int
+
+// special keys are sent by the terminal as escape sequences.
+const (
+	keyNone specialKey = iota
+	keyUnknown
+	keyUp
+	keyDown
+	keyRight
+	keyLeft
+	keyHome
+	keyEnd
+	keyDelete
+	keyPageUp
+	keyPageDown
+	keyPaste
+	keyMouse
+)
+
+type mouseAction int
+
+// the mouse actions.
+const (
+	mousePress mouseAction = iota
+	mouseRelease
+	mouseDrag
+	mouseWheelUp
+	mouseWheelDown
+)
+
+// mouseEvent is a mouse action at a screen position, counted from zero.
+type mouseEvent struct {
+	action mouseAction
+	button int
+	row    int
+	col    int
+}
+
+// pasteEnd ends text pasted in bracketed paste mode.
+var pasteEnd = []byte{byte(27), '[', '2', '0', '1', '~'}
+
+// key is a single decoded keypress.
+type key struct {
+	// b is the byte for plain and control keys.
+	b byte
+	// meta is set if the key was prefixed with escape, i.e. alt or meta was held.
+	meta bool
+	// special is set for keys sent as escape sequences.
+	special specialKey
+	// text is the text of a paste.
+	text string
+	// mouse is set for mouse events.
+	mouse mouseEvent
+}
+
+// readKey reads a single keypress, decoding escape sequences.
+func readKey(r io.ByteReader) (key, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return key{}, err
+	}
+	if b != ANSI.esc {
+		return key{b: b}, nil
+	}
+
+	// terminals send escape sequences all at once, so if nothing follows
+	// the escape straight away it was typed on its own.
+	if buffered, ok := r.(interface{ Buffered() int }); ok && buffered.Buffered() == 0 {
+		return key{b: b}, nil
+	}
+
+	b, err = r.ReadByte()
+	if err != nil {
+		return key{}, err
+	}
+	switch b {
+	case '[':
+		return readCSI(r)
+	case 'O': // some terminals send the arrows as ss3 sequences
+		b, err = r.ReadByte()
+		if err != nil {
+			return key{}, err
+		}
+		return key{special: csiFinal(b)}, nil
+	default:
+		return key{b: b, meta: true}, nil
+	}
+}
+
+// readCSI reads the rest of an escape sequence after `ESC [`.
+func readCSI(r io.ByteReader) (key, error) {
+	var params []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return key{}, err
+		}
+		if b >= 0x30 && b <= 0x3f { // parameter bytes
+			params = append(params, b)
+			continue
+		}
+		if (b == 'M' || b == 'm') && len(params) > 0 && params[0] == '<' {
+			return parseMouse(params[1:], b == 'm'), nil
+		}
+		if b == '~' && string(params) == "200" {
+			return readPaste(r)
+		}
+		if b == '~' {
+			return key{special: csiTilde(params)}, nil
+		}
+		return key{special: csiFinal(b)}, nil
+	}
+}
+
+// readPaste reads pasted text up to the end of the paste.
+func readPaste(r io.ByteReader) (key, error) {
+	var text []byte
+	for !bytes.HasSuffix(text, pasteEnd) {
+		b, err := r.ReadByte()
+		if err != nil {
+			return key{}, err
+		}
+		text = append(text, b)
+	}
+	return key{special: keyPaste, text: string(text[0 : len(text)-len(pasteEnd)])}, nil
+}
+
+// parseMouse parses the parameters of an sgr (1006) mouse report, `button;col;row`.
+func parseMouse(params []byte, release bool) key {
+	fields := bytes.Split(params, []byte{';'})
+	if len(fields) != 3 {
+		return key{special: keyUnknown}
+	}
+	var values [3]int
+	for x, field := range fields {
+		value, err := strconv.Atoi(string(field))
+		if err != nil {
+			return key{special: keyUnknown}
+		}
+		values[x] = value
+	}
+	if values[0] < 0 || values[1] < 1 || values[2] < 1 { // positions count from one
+		return key{special: keyUnknown}
+	}
+
+	event := mouseEvent{
+		button: values[0] & 3,
+		col:    values[1] - 1,
+		row:    values[2] - 1,
+	}
+	switch {
+	case values[0]&64 != 0 && values[0]&1 == 0:
+		event.action = mouseWheelUp
+	case values[0]&64 != 0:
+		event.action = mouseWheelDown
+	case release:
+		event.action = mouseRelease
+	case values[0]&32 != 0:
+		event.action = mouseDrag
+	default:
+		event.action = mousePress
+	}
+	return key{special: keyMouse, mouse: event}
+}
+
+func csiFinal(b byte) specialKey {
+	switch b {
+	case 'A':
+		return keyUp
+	case 'B':
+		return keyDown
+	case 'C':
+		return keyRight
+	case 'D':
+		return keyLeft
+	case 'H':
+		return keyHome
+	case 'F':
+		return keyEnd
+	}
+	return keyUnknown
+}
+
+func csiTilde(params []byte) specialKey {
+	code, err := strconv.Atoi(string(params))
+	if err != nil {
+		return keyUnknown
+	}
+	switch code {
+	case 1, 7:
+		return keyHome
+	case 4, 8:
+		return keyEnd
+	case 3:
+		return keyDelete
+	case 5:
+		return keyPageUp
+	case 6:
+		return keyPageDown
+	}
+	return keyUnknown
+}
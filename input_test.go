@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bufio"
+	"strings"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func readAllKeys(input string) []key {
+	reader := bufio.NewReader(strings.NewReader(input))
+	var keys []key
+	for {
+		k, err := readKey(reader)
+		if err != nil {
+			return keys
+		}
+		keys = append(keys, k)
+	}
+}
+
+func TestReadKey(t *testing.T) {
+	assert := assert.New(t)
+
+	keys := readAllKeys("a\x01\x1bf\x1b[A\x1bOD\x1b[3~\x1b[5;5~")
+	assert.Len(keys, 7)
+	assert.Equal(key{b: 'a'}, keys[0])
+	assert.Equal(key{b: ANSI.soh}, keys[1])
+	assert.Equal(key{b: 'f', meta: true}, keys[2])
+	assert.Equal(key{special: keyUp}, keys[3])
+	assert.Equal(key{special: keyLeft}, keys[4])
+	assert.Equal(key{special: keyDelete}, keys[5])
+	assert.Equal(key{special: keyUnknown}, keys[6])
+}
+
+func TestReadKeyPaste(t *testing.T) {
+	assert := assert.New(t)
+
+	keys := readAllKeys("x\x1b[200~func() {\r\x03\x1b[A}\x1b[201~y")
+	assert.Len(keys, 3)
+	assert.Equal(key{special: keyPaste, text: "func() {\r\x03\x1b[A}"}, keys[1])
+	assert.Equal(key{b: 'y'}, keys[2])
+}
+
+func TestProcessKeyPaste(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("ab")
+	state.settings = settingsForPath("main.go")
+	state = state.MoveRight()
+	state, err := processKey(key{special: keyPaste, text: "if x {\r\ty()\r}"}, state)
+	assert.Nil(err)
+	assert.Equal("aif x {\n\ty()\n}b", string(state.buffer.Bytes()))
+	assert.Equal(cursor{row: 2, col: 1}, state.cursor)
+
+	state, _ = processKey(key{b: ANSI.us}, state)
+	assert.Equal("ab", string(state.buffer.Bytes()))
+}
+
+func TestReadKeyMouse(t *testing.T) {
+	assert := assert.New(t)
+
+	keys := readAllKeys("\x1b[<0;5;2M\x1b[<32;7;3M\x1b[<0;7;3m\x1b[<64;1;1M\x1b[<65;1;1M")
+	assert.Len(keys, 5)
+	assert.Equal(mouseEvent{action: mousePress, row: 1, col: 4}, keys[0].mouse)
+	assert.Equal(mouseEvent{action: mouseDrag, row: 2, col: 6}, keys[1].mouse)
+	assert.Equal(mouseEvent{action: mouseRelease, row: 2, col: 6}, keys[2].mouse)
+	assert.Equal(mouseWheelUp, keys[3].mouse.action)
+	assert.Equal(mouseWheelDown, keys[4].mouse.action)
+}
+
+func TestProcessKeyMouse(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("zero\n\tone\ntwo\nthree\nfour\nfive")
+	state = state.Resize(80, 4)
+	state = state.Scroll(1)
+	assert.Equal(1, state.scroll)
+	assert.Equal(cursor{row: 1, col: 0}, state.cursor)
+
+	// the tab is four columns wide
+	state, _ = processKey(key{special: keyMouse, mouse: mouseEvent{action: mousePress, row: 0, col: 5}}, state)
+	assert.Equal(cursor{row: 1, col: 2}, state.cursor)
+	state, _ = processKey(key{special: keyMouse, mouse: mouseEvent{action: mouseDrag, row: 1, col: 2}}, state)
+	state, _ = processKey(key{special: keyMouse, mouse: mouseEvent{action: mouseRelease, row: 1, col: 2}}, state)
+	from, to, ok := state.Selection()
+	assert.True(ok)
+	assert.Equal(cursor{row: 1, col: 2}, from)
+	assert.Equal(cursor{row: 2, col: 2}, to)
+
+	state, _ = processKey(key{b: ANSI.etb}, state)
+	assert.Equal("\too\nthree", string(state.buffer.Text(cursor{row: 1}, cursor{row: 2, col: 5})))
+	assert.Equal("ne\ntw", string(state.clipboard.text))
+
+	state, _ = processKey(key{special: keyMouse, mouse: mouseEvent{action: mouseWheelDown}}, state)
+	assert.Equal(4, state.scroll)
+	assert.Equal(4, state.cursor.row)
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// specialKeyNames are the names of the special keys in key notation.
+var specialKeyNames = map[specialKey]string{
+	keyUp:       "<Up>",
+	keyDown:     "<Down>",
+	keyRight:    "<Right>",
+	keyLeft:     "<Left>",
+	keyHome:     "<Home>",
+	keyEnd:      "<End>",
+	keyDelete:   "<Delete>",
+	keyPageUp:   "<PageUp>",
+	keyPageDown: "<PageDown>",
+}
+
+// pasteKeyName is followed by the quoted text of a paste in key notation.
+const pasteKeyName = "<Paste>"
+
+// byteKeyNames are the names of the keys that aren't written as themselves in key notation.
+var byteKeyNames = map[byte]string{
+	ANSI.tab: "<TAB>",
+	ANSI.cr:  "<RET>",
+	ANSI.esc: "<ESC>",
+	ANSI.del: "<DEL>",
+	' ':      "<SPC>",
+}
+
+// String returns the key in key notation, e.g. `C-a`, `M-f` or `<Down>`.
+func (k key) String() string {
+	if k.special == keyPaste {
+		return pasteKeyName + " " + strconv.Quote(k.text)
+	}
+	if k.special != keyNone {
+		if name, ok := specialKeyNames[k.special]; ok {
+			return name
+		}
+		return "<unknown>"
+	}
+
+	var name string
+	if byteName, ok := byteKeyNames[k.b]; ok {
+		name = byteName
+	} else if k.b < ' ' {
+		name = "C-" + string(rune(controlKeyBase(k.b)))
+	} else {
+		name = string([]byte{k.b})
+	}
+	if k.meta {
+		return "M-" + name
+	}
+	return name
+}
+
+// notated returns if the key can be written in key notation; mouse events and keys the
+// terminal sent that aren't known can't be.
+func (k key) notated() bool {
+	if k.special == keyNone || k.special == keyPaste {
+		return true
+	}
+	_, ok := specialKeyNames[k.special]
+	return ok
+}
+
+// controlKeyBase returns the key that is held with control to type a control byte.
+func controlKeyBase(b byte) byte {
+	if b >= 1 && b <= 26 {
+		return b - 1 + 'a'
+	}
+	return b + '@'
+}
+
+// formatKeys returns keys in key notation, separated by spaces, with runs of text quoted.
+func formatKeys(keys []key) string {
+	var tokens []string
+	var text []byte
+	for _, k := range keys {
+		if k.special == keyNone && !k.meta && k.b >= ' ' && k.b != ANSI.del {
+			text = append(text, k.b)
+			continue
+		}
+		if len(text) > 0 {
+			tokens = append(tokens, strconv.Quote(string(text)))
+			text = nil
+		}
+		tokens = append(tokens, k.String())
+	}
+	if len(text) > 0 {
+		tokens = append(tokens, strconv.Quote(string(text)))
+	}
+	return strings.Join(tokens, " ")
+}
+
+// parseKeys parses keys written in key notation.
+// Tokens are separated by spaces, and are either key names like `C-a`, `M-<`, `<Down>`,
+// text in double quotes, or any other text, which is typed as is. The spaces between
+// tokens are only separators, so `a b` types "ab": spaces are typed as `<SPC>` or inside quotes.
+func parseKeys(notation string) ([]key, error) {
+	var keys []key
+	for len(notation) > 0 {
+		notation = strings.TrimLeft(notation, " \t\r\n")
+		if len(notation) == 0 {
+			break
+		}
+
+		if notation[0] == '"' {
+			quoted, err := strconv.QuotedPrefix(notation)
+			if err != nil {
+				return nil, fmt.Errorf("bad quoted text: %s", notation)
+			}
+			text, _ := strconv.Unquote(quoted)
+			for x := 0; x < len(text); x++ {
+				keys = append(keys, key{b: text[x]})
+			}
+			notation = notation[len(quoted):]
+			continue
+		}
+
+		token := notation
+		if end := strings.IndexAny(notation, " \t\r\n"); end >= 0 {
+			token = notation[0:end]
+		}
+		notation = notation[len(token):]
+
+		if token == pasteKeyName {
+			notation = strings.TrimLeft(notation, " \t\r\n")
+			quoted, err := strconv.QuotedPrefix(notation)
+			if err != nil {
+				return nil, fmt.Errorf("%s must be followed by quoted text", pasteKeyName)
+			}
+			text, _ := strconv.Unquote(quoted)
+			keys = append(keys, key{special: keyPaste, text: text})
+			notation = notation[len(quoted):]
+			continue
+		}
+		if k, ok := parseKeyName(token); ok {
+			keys = append(keys, k)
+			continue
+		}
+		for x := 0; x < len(token); x++ {
+			keys = append(keys, key{b: token[x]})
+		}
+	}
+	return keys, nil
+}
+
+// parseKeyName parses a single key name, like `C-a` or `M-<Down>`.
+func parseKeyName(name string) (key, bool) {
+	var meta bool
+	if strings.HasPrefix(name, "M-") && len(name) > 2 {
+		meta = true
+		name = name[2:]
+	}
+
+	for special, specialName := range specialKeyNames {
+		if strings.EqualFold(name, specialName) {
+			return key{special: special}, true
+		}
+	}
+	for b, byteName := range byteKeyNames {
+		if strings.EqualFold(name, byteName) {
+			return key{b: b, meta: meta}, true
+		}
+	}
+	if strings.HasPrefix(name, "C-") && len(name) == 3 {
+		base := name[2]
+		if base >= 'a' && base <= 'z' {
+			return key{b: base - 'a' + 1, meta: meta}, true
+		}
+		if base >= '@' && base <= '_' {
+			return key{b: base - '@', meta: meta}, true
+		}
+		if base == '/' { // C-/ is sent as C-_
+			return key{b: ANSI.us, meta: meta}, true
+		}
+	}
+	if meta && len(name) == 1 {
+		return key{b: name[0], meta: true}, true
+	}
+	return key{}, false
+}
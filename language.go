@@ -0,0 +1,93 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+)
+
+// language holds the indentation and syntax rules for a kind of file.
+type language struct {
+	name string
+	// indent is the default indent unit.
+	indent string
+	// indentAfter are the bytes that indent the next row when they end a row.
+	indentAfter string
+	// dedentOn are the bytes that dedent a row when typed as its first non-blank byte.
+	dedentOn string
+
+	// quotes are the bytes that start and end strings, "`" strings may span rows.
+	quotes string
+	// lineComment starts a comment that runs to the end of the row.
+	lineComment string
+	// blockComments are set if `/* */` comments are supported.
+	blockComments bool
+}
+
+var (
+	languageText = language{name: "text", indent: "\t"}
+	languageGo   = language{name: "go", indent: "\t", indentAfter: "{([", dedentOn: "})]", quotes: "\"'`", lineComment: "//", blockComments: true}
+	languageC    = language{name: "c", indent: "\t", indentAfter: "{([", dedentOn: "})]", quotes: "\"'", lineComment: "//", blockComments: true}
+	languageJS   = language{name: "javascript", indent: "\t", indentAfter: "{([", dedentOn: "})]", quotes: "\"'`", lineComment: "//", blockComments: true}
+	// `'` isn't a quote in rust, as it also starts lifetimes like `'a`.
+	languageRust = language{name: "rust", indent: "\t", indentAfter: "{([", dedentOn: "})]", quotes: "\"", lineComment: "//", blockComments: true}
+	languagePy   = language{name: "python", indent: "    ", indentAfter: ":{([", dedentOn: "})]", quotes: "\"'", lineComment: "#"}
+	languageYAML = language{name: "yaml", indent: "  ", indentAfter: ":", quotes: "\"'", lineComment: "#"}
+)
+
+// languages maps file extensions to their language.
+var languages = map[string]language{
+	".go":   languageGo,
+	".c":    languageC,
+	".h":    languageC,
+	".cpp":  languageC,
+	".java": languageC,
+	".js":   languageJS,
+	".ts":   languageJS,
+	".json": languageC,
+	".rs":   languageRust,
+	".py":   languagePy,
+	".yml":  languageYAML,
+	".yaml": languageYAML,
+}
+
+// languageForPath returns the language for a file by its extension.
+func languageForPath(path string) language {
+	if lang, ok := languages[strings.ToLower(filepath.Ext(path))]; ok {
+		return lang
+	}
+	return languageText
+}
+
+// IndentsAfter returns if a row ending with the given text should indent the next row.
+func (l language) IndentsAfter(text []byte) bool {
+	text = trimTrailingWhitespace(text)
+	if len(text) == 0 {
+		return false
+	}
+	return strings.IndexByte(l.indentAfter, text[len(text)-1]) >= 0
+}
+
+// DedentsOn returns if typing a byte as the first non-blank byte of a row dedents it.
+func (l language) DedentsOn(b byte) bool {
+	return strings.IndexByte(l.dedentOn, b) >= 0
+}
+
+func isBlank(b byte) bool {
+	return b == ' ' || b == byteTab
+}
+
+func leadingWhitespace(text []byte) []byte {
+	var x int
+	for x < len(text) && isBlank(text[x]) {
+		x++
+	}
+	return text[0:x]
+}
+
+func trimTrailingWhitespace(text []byte) []byte {
+	x := len(text)
+	for x > 0 && isBlank(text[x-1]) {
+		x--
+	}
+	return text[0:x]
+}
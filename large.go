@@ -0,0 +1,490 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"syscall"
+)
+
+// largeFileSize is the size above which files are mapped into memory rather than read;
+// tests lower it to map small files.
+var largeFileSize int64 = 64 << 20
+
+const (
+	// largeChunkSize is the size of the pieces a mapped file starts as, so finding a row
+	// only scans the piece it's in.
+	largeChunkSize = 1 << 20
+	// largeWindowRows and largeWindowBytes bound how much of a large file is in the buffer at once;
+	// a row longer than largeWindowBytes is cut, so only part of it is in the buffer.
+	largeWindowRows  = 4096
+	largeWindowBytes = 4 << 20
+)
+
+// errMappingFault is returned when the mapping of a large file can't be read, because
+// something else has truncated the file.
+var errMappingFault = errors.New("the file was cut short on disk, and can't be read")
+
+// mapping is a private mapping of a file, shared by every version of its text.
+type mapping struct {
+	data []byte
+	// stamp is the file as it was mapped, which recovery files are patches against.
+	stamp fileStamp
+	// previous is the mapping from before the file was last saved, which undoing may still read.
+	previous *mapping
+}
+
+// mapFile maps a file privately and read only.
+func mapFile(f *os.File, size int64) (*mapping, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() != size {
+		return nil, errors.New("the file changed size while it was being mapped")
+	}
+	m := &mapping{stamp: fileStamp{exists: true, modTime: info.ModTime(), size: size}}
+	if size > 0 {
+		if m.data, err = syscall.Mmap(int(f.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_PRIVATE); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// close unmaps the file, and the files it was saved over; the text in them can't be read afterwards.
+func (m *mapping) close() {
+	for ; m != nil; m = m.previous {
+		if m.data != nil {
+			syscall.Munmap(m.data)
+			m.data = nil
+		}
+	}
+}
+
+// readMapping runs f, returning errMappingFault rather than crashing if f reads a page
+// of the mapping that the file no longer has.
+func readMapping(f func() error) (err error) {
+	defer debug.SetPanicOnFault(debug.SetPanicOnFault(true))
+	defer func() {
+		if r := recover(); r != nil {
+			if _, ok := r.(interface{ Addr() uintptr }); !ok {
+				panic(r)
+			}
+			err = errMappingFault
+		}
+	}()
+	return f()
+}
+
+// piece is a run of a large file's text, in the mapping or in memory, and the newlines in it.
+type piece struct {
+	data     []byte
+	newlines int
+	// offset is where the piece is in the mapped file, or -1 if it's an edit held in memory.
+	offset int64
+}
+
+// largeText is a large file as a piece table over its mapping, so memory grows with the
+// edits rather than the size of the file. The buffer holds a window of its text, from start
+// up to end, which begins col bytes into row first; the pieces hold the text as it was when
+// the window was taken, and edits to the window are only put in the pieces when another
+// window is taken.
+type largeText struct {
+	mapping    *mapping
+	pieces     []piece
+	start, end int64
+	first, col int
+	window     buffer
+	// taken is the buffer the window was taken in place of.
+	taken buffer
+}
+
+// rows returns the number of rows in the pieces.
+func (lt largeText) rows() int {
+	rows := 1
+	for _, p := range lt.pieces {
+		rows += p.newlines
+	}
+	return rows
+}
+
+// size returns the number of bytes in the pieces.
+func (lt largeText) size() int64 {
+	var size int64
+	for _, p := range lt.pieces {
+		size += int64(len(p.data))
+	}
+	return size
+}
+
+// rowOffset returns the offset a row starts at.
+func (lt largeText) rowOffset(row int) int64 {
+	var offset int64
+	for _, p := range lt.pieces {
+		if row > p.newlines {
+			row -= p.newlines
+			offset += int64(len(p.data))
+			continue
+		}
+		// the row starts after the row'th newline in this piece.
+		data := p.data
+		for ; row > 0; row-- {
+			data = data[bytes.IndexByte(data, byteNewLine)+1:]
+		}
+		return offset + int64(len(p.data)-len(data))
+	}
+	return offset
+}
+
+// rowAt returns the row an offset is in.
+func (lt largeText) rowAt(offset int64) int {
+	var row int
+	for _, p := range lt.pieces {
+		if offset >= int64(len(p.data)) {
+			offset -= int64(len(p.data))
+			row += p.newlines
+			continue
+		}
+		return row + bytes.Count(p.data[0:offset], []byte{byteNewLine})
+	}
+	return row
+}
+
+// read copies the text from one offset up to another.
+func (lt largeText) read(from, to int64) []byte {
+	text := make([]byte, 0, to-from)
+	_, after := splitPieces(lt.pieces, from)
+	for _, p := range after {
+		if int64(len(text)+len(p.data)) >= to-from {
+			return append(text, p.data[0:to-from-int64(len(text))]...)
+		}
+		text = append(text, p.data...)
+	}
+	return text
+}
+
+// splitPieces splits pieces at an offset, leaving the pieces passed in as they were.
+func splitPieces(pieces []piece, offset int64) (before, after []piece) {
+	for x, p := range pieces {
+		if offset >= int64(len(p.data)) {
+			offset -= int64(len(p.data))
+			continue
+		}
+		if offset == 0 {
+			return pieces[0:x:x], pieces[x:]
+		}
+		head, tail := p.data[0:offset:offset], p.data[offset:]
+		newlines := bytes.Count(head, []byte{byteNewLine})
+		tailOffset := p.offset
+		if p.offset >= 0 {
+			tailOffset += offset
+		}
+		before = append(pieces[0:x:x], piece{data: head, newlines: newlines, offset: p.offset})
+		after = append([]piece{{data: tail, newlines: p.newlines - newlines, offset: tailOffset}}, pieces[x+1:]...)
+		return before, after
+	}
+	return pieces, nil
+}
+
+// commit returns the text with the buffer in place of the window it was taken as.
+func (lt largeText) commit(b buffer) (largeText, error) {
+	if b.Same(lt.window) {
+		return lt, nil
+	}
+	err := readMapping(func() error {
+		before, _ := splitPieces(lt.pieces, lt.start)
+		_, after := splitPieces(lt.pieces, lt.end)
+		pieces := append(make([]piece, 0, len(before)+1+len(after)), before...)
+		edited := b.Bytes()
+		if len(edited) > 0 {
+			pieces = append(pieces, piece{data: edited, newlines: len(b) - 1, offset: -1})
+		}
+		lt.pieces = append(pieces, after...)
+		lt.end = lt.start + int64(len(edited))
+		return nil
+	})
+	if err != nil {
+		return lt, err
+	}
+	lt.window = b
+	return lt, nil
+}
+
+// windowAround returns the text with the text around a position taken as the window: the
+// rows around it, as many as fit in largeWindowRows and largeWindowBytes, or if its row
+// doesn't fit, largeWindowBytes of the row around it.
+func (lt largeText) windowAround(at cursor) (largeText, error) {
+	err := readMapping(func() error {
+		rows, size := lt.rows(), lt.size()
+		row := maxInt(0, minInt(at.row, rows-1))
+		rowStart, rowEnd := lt.rowOffset(row), size
+		if row < rows-1 {
+			rowEnd = lt.rowOffset(row+1) - 1
+		}
+		target := rowStart + int64(maxInt(0, at.col))
+		if target > rowEnd || target < rowStart { // past the end of the row, or overflowed
+			target = rowEnd
+		}
+
+		first := maxInt(0, row-largeWindowRows/2)
+		if rowStart-lt.rowOffset(first) > largeWindowBytes/2 {
+			first = minInt(row, lt.rowAt(rowStart-largeWindowBytes/2)+1)
+		}
+		start := lt.rowOffset(first)
+		last := minInt(rows, first+largeWindowRows)
+		if last < rows && lt.rowOffset(last)-start > largeWindowBytes {
+			last = maxInt(row+1, lt.rowAt(start+largeWindowBytes))
+		}
+		end := size
+		if last < rows {
+			end = lt.rowOffset(last) - 1
+		}
+
+		col := 0
+		if end-start > largeWindowBytes { // the row is too long, so the window is part of it
+			first, start = row, target-largeWindowBytes/2
+			if start > rowEnd-largeWindowBytes {
+				start = rowEnd - largeWindowBytes
+			}
+			if start < rowStart {
+				start = rowStart
+			}
+			end = start + largeWindowBytes
+			if end > rowEnd {
+				end = rowEnd
+			}
+			col = int(start - rowStart)
+		}
+
+		lt.start, lt.end, lt.first, lt.col = start, end, first, col
+		lt.window = bufferFromBytes(lt.read(start, end))
+		return nil
+	})
+	return lt, err
+}
+
+// fileCursor returns a position in the window counted from the start of the file.
+func (lt largeText) fileCursor(at cursor) cursor {
+	if at.row == 0 {
+		at.col += lt.col
+	}
+	at.row += lt.first
+	return at
+}
+
+// windowCursor returns a position counted from the start of the file in the window,
+// which may be outside it.
+func (lt largeText) windowCursor(at cursor) cursor {
+	at.row -= lt.first
+	if at.row == 0 {
+		at.col -= lt.col
+	}
+	return at
+}
+
+// writeTo writes the text with the buffer in place of the window, a piece at a time.
+func (lt largeText) writeTo(w io.Writer, b buffer) error {
+	return readMapping(func() error {
+		before, _ := splitPieces(lt.pieces, lt.start)
+		_, after := splitPieces(lt.pieces, lt.end)
+		buffered := bufio.NewWriter(w)
+		for _, p := range before {
+			buffered.Write(p.data)
+		}
+		buffered.Write(b.Bytes())
+		for _, p := range after {
+			buffered.Write(p.data)
+		}
+		return buffered.Flush()
+	})
+}
+
+// stateFromMappedFile maps a file into memory and counts the newlines in each piece of it.
+// The kernel pages the file in as it's read, and only the window of rows around the cursor
+// is copied into the buffer.
+func stateFromMappedFile(f *os.File, size int64) (editorState, error) {
+	es := newEditorState()
+	if size == 0 {
+		return es, nil
+	}
+	m, err := mapFile(f, size)
+	if err != nil {
+		return editorState{}, err
+	}
+
+	lt := largeText{mapping: m}
+	var binary bool
+	err = readMapping(func() error {
+		lt.pieces = mappedPieces(m.data, 0)
+		binary = isBinary(m.data)
+		return nil
+	})
+	if err == nil {
+		lt, err = lt.windowAround(cursor{})
+	}
+	if err != nil {
+		lt.mapping.close()
+		return editorState{}, err
+	}
+
+	es.buffer = lt.window
+	es.saved = es.buffer
+	es.autosaved = es.buffer
+	es.large = &lt
+	if binary { // hex offsets count from the start of the buffer, not the file
+		es.message = "binary file, too large to show as hex"
+	}
+	return es, nil
+}
+
+// mappedPieces cuts a run of a mapped file, from an offset in it, into pieces and counts
+// the newlines in them.
+func mappedPieces(data []byte, offset int64) []piece {
+	pieces := make([]piece, 0, len(data)/largeChunkSize+1)
+	for start := 0; start < len(data); start += largeChunkSize {
+		end := minInt(start+largeChunkSize, len(data))
+		// the capacity is capped so appending to a piece copies it rather than writing to the mapping.
+		chunk := data[start:end:end]
+		pieces = append(pieces, piece{data: chunk, newlines: bytes.Count(chunk, []byte{byteNewLine}), offset: offset + int64(start)})
+	}
+	return pieces
+}
+
+// remapLarge maps the file a large file was just saved to in place of the text it was written
+// from, so the edits are no longer held in memory, and later autosaves patch the saved file.
+func (es editorState) remapLarge() (editorState, error) {
+	lt, err := es.large.commit(es.buffer)
+	if err != nil {
+		return es, err
+	}
+	f, err := os.Open(es.path)
+	if err != nil {
+		return es, err
+	}
+	defer f.Close()
+	m, err := mapFile(f, lt.size())
+	if err != nil {
+		return es, err
+	}
+
+	// the pieces are where they were written, so their newlines are already counted.
+	m.previous = lt.mapping
+	pieces := make([]piece, len(lt.pieces))
+	var offset int64
+	for x, p := range lt.pieces {
+		end := offset + int64(len(p.data))
+		pieces[x] = piece{data: m.data[offset:end:end], newlines: p.newlines, offset: offset}
+		offset = end
+	}
+	lt.mapping, lt.pieces = m, pieces
+	es.large = &lt
+	return es, nil
+}
+
+// largeRows returns the number of rows in a large file, with the edits to the window.
+func (es editorState) largeRows() int {
+	return es.large.rows() - len(es.large.window) + len(es.buffer)
+}
+
+// moveToLargeRow moves the cursor to the start of a row of a large file, taking the rows
+// around it into the buffer.
+func (es editorState) moveToLargeRow(row int) editorState {
+	return es.takeLargeWindow(cursor{row: row})
+}
+
+// slideLargeWindow takes the text around the cursor into the buffer when it nears either
+// end of the window, so moving through a large file doesn't stop at the window's edge.
+func (es editorState) slideLargeWindow() editorState {
+	lt := es.large
+	rows, quarter := len(es.buffer), (len(es.buffer)+3)/4
+	offset := es.buffer.Offset(es.cursor)
+	size := es.buffer.Offset(cursor{row: rows - 1, col: len(es.buffer[rows-1])})
+	nearStart := es.cursor.row < quarter && offset < largeWindowBytes/4 && (lt.first > 0 || lt.col > 0)
+	nearEnd := rows-1-es.cursor.row < quarter && size-offset < largeWindowBytes/4 && lt.end < lt.size()
+	if !nearStart && !nearEnd {
+		return es
+	}
+	return es.takeLargeWindow(lt.fileCursor(es.cursor))
+}
+
+// takeLargeWindow puts the edits to the window in the pieces, then takes the text around
+// a position counted from the start of the file as the window, and moves the cursor to it.
+func (es editorState) takeLargeWindow(at cursor) editorState {
+	lt, err := es.large.commit(es.buffer)
+	if err == nil {
+		lt, err = lt.windowAround(at)
+	}
+	if err != nil {
+		es.message = err.Error()
+		return es
+	}
+
+	// taking a window isn't a change to the file.
+	modified, autosaved := es.Modified(), es.buffer.Same(es.autosaved)
+	mark := es.large.fileCursor(es.mark)
+	shift := lt.first - es.large.first
+	lt.taken = es.buffer
+	es.buffer = lt.window
+	es.large = &lt
+	if !modified {
+		es.saved = es.buffer
+	}
+	if autosaved {
+		es.autosaved = es.buffer
+	}
+	es.cursor = es.buffer.Clamp(lt.windowCursor(at))
+	es.mark = es.buffer.Clamp(lt.windowCursor(mark))
+	es.scroll = maxInt(0, es.scroll-shift)
+	return es
+}
+
+// sameText returns if two states have the same text, i.e. neither was derived from the other
+// by an edit. Taking another window of a large file as the cursor moves isn't an edit.
+func (es editorState) sameText(other editorState) bool {
+	if es.buffer.Same(other.buffer) {
+		return true
+	}
+	return es.large != nil && es.buffer.Same(es.large.window) && es.large.taken.Same(other.buffer)
+}
+
+// closeLarge unmaps a large file once nothing will read it again.
+func (es editorState) closeLarge() {
+	if es.large != nil {
+		es.large.mapping.close()
+	}
+}
+
+// writeText writes the rows of the buffer, streaming the rest of a large file around them.
+func (es editorState) writeText(w io.Writer) error {
+	if es.large != nil {
+		return es.large.writeTo(w, es.buffer)
+	}
+	_, err := w.Write(es.buffer.Bytes())
+	return err
+}
+
+// writeReplacing writes a file by renaming a new file over it, which leaves the
+// old contents in place for any mapping of them.
+func writeReplacing(path string, mode os.FileMode, write func(io.Writer) error) error {
+	temp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	if err := write(temp); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), mode); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
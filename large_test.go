@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func TestStateFromMappedFile(t *testing.T) {
+	assert := assert.New(t)
+
+	for _, contents := range []string{"one\ntwo\n", "one\ntwo", "\n\n", "x"} {
+		path := filepath.Join(t.TempDir(), "big.log")
+		assert.Nil(os.WriteFile(path, []byte(contents), 0644))
+		f, err := os.Open(path)
+		assert.Nil(err)
+		defer f.Close()
+
+		mapped, err := stateFromMappedFile(f, int64(len(contents)))
+		assert.Nil(err)
+		assert.NotNil(mapped.large)
+		assert.Equal(contents, string(mapped.buffer.Bytes()))
+		assert.Equal(len(stateFromReader(f).buffer), len(mapped.buffer))
+	}
+}
+
+func TestMappedFileEditAndSave(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "big.log")
+	assert.Nil(os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0644))
+	f, err := os.Open(path)
+	assert.Nil(err)
+	defer f.Close()
+
+	state, err := stateFromMappedFile(f, 14)
+	assert.Nil(err)
+	state.path = path
+	state.disk, err = stampFile(path)
+	assert.Nil(err)
+
+	// edits copy rows, the mapping is read only.
+	state = typeNotation(state, `C-e "!" <Down> M-k`)
+	assert.Equal("one!\nthree\n", string(state.buffer.Bytes()))
+	assert.Equal("one\ntwo\nthree\n", string(state.saved.Bytes()))
+
+	state = state.Save()
+	assert.Equal("wrote "+path, state.message)
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("one!\nthree\n", string(written))
+	// the old rows are still readable after the file is replaced.
+	assert.Equal("one\ntwo\nthree\n", string(state.Undo().Undo().buffer.Bytes()))
+}
+
+// mapRows writes a file of numbered rows, and maps it like a large file.
+func mapRows(t *testing.T, rows int) (editorState, string) {
+	var text bytes.Buffer
+	for row := 0; row < rows; row++ {
+		fmt.Fprintf(&text, "%015d\n", row)
+	}
+	return mapText(t, text.Bytes())
+}
+
+// mapText writes a file, and maps it like a large file.
+func mapText(t *testing.T, text []byte) (editorState, string) {
+	path := filepath.Join(t.TempDir(), "big.log")
+	if err := os.WriteFile(path, text, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return mapPath(t, path), path
+}
+
+// mapPath maps a file like a large file.
+func mapPath(t *testing.T, path string) editorState {
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := stateFromMappedFile(f, info.Size())
+	if err != nil {
+		t.Fatal(err)
+	}
+	state.path = path
+	state.disk, _ = stampFile(path)
+	t.Cleanup(state.closeLarge)
+	return state.Resize(80, 24)
+}
+
+func TestLargeFileWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	// over a megabyte, so the text starts as more than one piece.
+	state, _ := mapRows(t, 100000)
+	assert.Len(state.large.pieces, 2)
+	assert.Len(state.buffer, largeWindowRows)
+	assert.Equal(100001, state.largeRows())
+
+	state = typeNotation(state, `M->`)
+	assert.Equal(100000, state.large.first+state.cursor.row)
+	assert.Equal("", string(state.buffer[state.cursor.row]))
+	assert.Equal(fmt.Sprintf("%015d", 99999), string(state.buffer[state.cursor.row-1]))
+	assert.False(state.Modified())
+
+	state = typeNotation(state, `M-g "50000" <RET>`)
+	assert.Equal(fmt.Sprintf("%015d", 49999), string(state.buffer[state.cursor.row]))
+
+	// moving past the end of the window takes the rows after it.
+	first := state.large.first
+	state.cursor.row = len(state.buffer) - 2
+	row := state.large.first + state.cursor.row
+	state = typeNotation(state, `C-n C-n`)
+	assert.True(state.large.first > first)
+	assert.Equal(row+2, state.large.first+state.cursor.row)
+	assert.Equal(fmt.Sprintf("%015d", row+2), string(state.buffer[state.cursor.row]))
+	assert.False(state.Modified())
+}
+
+func TestLargeFileEditsOutsideTheWindow(t *testing.T) {
+	assert := assert.New(t)
+
+	state, path := mapRows(t, 100000)
+	state = typeNotation(state, `M-g "70000" <RET> "edited " <RET> M-< "first " M->`)
+	assert.True(state.Modified())
+	assert.Equal(100002, state.largeRows())
+
+	state = typeNotation(state, `M-g "70000" <RET>`)
+	assert.Equal("edited ", string(state.buffer[state.cursor.row]))
+
+	state = typeNotation(state, `C-x C-s`)
+	assert.Equal("wrote "+path, state.message)
+	assert.False(state.Modified())
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal(100000*16+len("first ")+len("edited \n"), len(written))
+	assert.True(bytes.HasPrefix(written, []byte("first 000000000000000\n")))
+	assert.Contains(string(written), fmt.Sprintf("%015d\nedited \n%015d\n", 69998, 69999))
+
+	// undoing goes back through the windows the edits were made in, to the text as it was loaded.
+	for state.undo != nil {
+		state = state.Undo()
+	}
+	assert.True(state.Modified())
+	assert.Equal(fmt.Sprintf("%015d", 69999), string(state.buffer[state.cursor.row]))
+	state = typeNotation(state, `M-<`)
+	assert.Equal(fmt.Sprintf("%015d", 0), string(state.buffer[0]))
+}
+
+func TestLargeFileUndoToLoaded(t *testing.T) {
+	assert := assert.New(t)
+
+	state, _ := mapRows(t, 20000)
+	state = typeNotation(state, `"x" M->`)
+	assert.True(state.Modified())
+	state = typeNotation(state, `C-_`)
+	assert.False(state.Modified())
+	assert.Equal(fmt.Sprintf("%015d", 0), string(state.buffer[0]))
+}
+
+func TestLargeFileAutosavePatches(t *testing.T) {
+	assert := assert.New(t)
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+
+	// over a megabyte, and the window is a small part of it.
+	state, path := mapRows(t, 100000)
+	state = typeNotation(state, `M-> "end" M-<`).Autosave()
+	assert.True(state.ownsRecovery)
+	patch, err := os.ReadFile(recoveryPath(path))
+	assert.Nil(err)
+	assert.True(len(patch) < 100000)
+
+	// make sure the recovery file is newer than the file, without touching the file.
+	later := time.Now().Add(time.Minute)
+	assert.Nil(os.Chtimes(recoveryPath(path), later, later))
+	recovered := typeNotation(mapPath(t, path).CheckRecovery(), `r`)
+	assert.Equal("recovered unsaved changes, save to keep them", recovered.message)
+	assert.True(recovered.Modified())
+	recovered = typeNotation(recovered, `M->`)
+	assert.Equal("end", string(recovered.buffer[recovered.cursor.row]))
+
+	// after saving, autosaves patch the saved file.
+	state = typeNotation(state, `C-x C-s "start" C-x C-s "!"`).Autosave()
+	assert.Nil(os.Chtimes(recoveryPath(path), later, later))
+	recovered = typeNotation(mapPath(t, path).CheckRecovery(), `r`)
+	assert.Equal("start!000000000000000", string(recovered.buffer[0]))
+	recovered = typeNotation(recovered, `M->`)
+	assert.Equal("end", string(recovered.buffer[recovered.cursor.row]))
+
+	// a patch against a file that's since changed isn't applied.
+	assert.Nil(os.WriteFile(path, []byte("changed\n"), 0644))
+	recovered = typeNotation(mapPath(t, path).CheckRecovery(), `r`)
+	assert.Equal("can't recover: the file has changed since the unsaved changes were kept, the recovery file is kept", recovered.message)
+	assert.Equal("changed", string(recovered.buffer[0]))
+	state.RemoveRecovery()
+}
+
+func TestLargeFileTruncatedOnDisk(t *testing.T) {
+	assert := assert.New(t)
+
+	state, path := mapRows(t, 100000)
+	assert.Nil(os.Truncate(path, 0))
+	state = typeNotation(state, `M->`)
+	assert.Equal(errMappingFault.Error(), state.message)
+	assert.Equal(0, state.large.first)
+
+	// the reload unmaps the old text.
+	mapping := state.large.mapping
+	state = state.Reload()
+	assert.Nil(mapping.data)
+	assert.Nil(state.undo)
+	assert.Equal("", string(state.buffer.Bytes()))
+}
+
+func TestLargeFileLongRow(t *testing.T) {
+	assert := assert.New(t)
+
+	// one row, three windows long.
+	text := bytes.Repeat([]byte("0123456789abcdef"), 3*largeWindowBytes/16)
+	state, path := mapText(t, text)
+	assert.Len(state.buffer, 1)
+	assert.Len(state.buffer[0], largeWindowBytes)
+	assert.Equal(0, state.large.col)
+
+	state = typeNotation(state, `C-e`)
+	assert.Equal(len(text), state.large.fileCursor(state.cursor).col)
+	assert.Len(state.buffer[0], largeWindowBytes)
+	assert.Equal(len(text)-largeWindowBytes, state.large.col)
+
+	state = typeNotation(state, `"x" C-a`)
+	assert.Equal(0, state.large.col)
+	assert.Equal(cursor{}, state.cursor)
+
+	// moving past the end of the window takes the text after it.
+	state.cursor.col = largeWindowBytes - 1
+	state = typeNotation(state, `C-f`)
+	assert.True(state.large.col > 0)
+	assert.Equal(cursor{col: largeWindowBytes}, state.large.fileCursor(state.cursor))
+	assert.Equal(byte('0'), state.buffer[0][state.cursor.col])
+	assert.True(len(state.buffer[0]) <= largeWindowBytes)
+
+	state = typeNotation(state, `C-x C-s`)
+	assert.False(state.Modified())
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal(append(text, 'x'), written)
+}
+
+func TestLargeFileChangedOnDisk(t *testing.T) {
+	assert := assert.New(t)
+
+	state, path := mapRows(t, 100000)
+	state = typeNotation(state, `M-g "50000" <RET> C-f C-f`)
+
+	// a log being appended to isn't reloaded on every check.
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0)
+	assert.Nil(err)
+	fmt.Fprintf(f, "%015d\n", 100000)
+	assert.Nil(f.Close())
+	mapping := state.large.mapping
+	state = state.CheckDisk()
+	assert.True(state.diskChanged)
+	assert.Equal(path+" changed on disk, M-x revert to reload it", state.message)
+	assert.True(state.large.mapping == mapping)
+
+	size := largeFileSize
+	defer func() { largeFileSize = size }()
+	largeFileSize = 1 << 20
+	state = typeNotation(state, `M-x "revert" <RET>`)
+	assert.False(state.diskChanged)
+	assert.NotNil(state.large)
+	assert.Equal(100002, state.largeRows())
+	assert.Equal(cursor{row: 49999, col: 2}, state.large.fileCursor(state.cursor))
+	assert.Nil(mapping.data)
+
+	// a file reloaded small enough to read keeps the cursor on its row too.
+	largeFileSize = size
+	state = typeNotation(state, `M-x "revert" <RET>`)
+	assert.Nil(state.large)
+	assert.Equal(cursor{row: 49999, col: 2}, state.cursor)
+}
+
+func TestLargeFileIsWrittenRaw(t *testing.T) {
+	assert := assert.New(t)
+
+	state, path := mapText(t, []byte("\xff\xfeo\x00n\x00e\x00\r\x00\n\x00"))
+	assert.Equal("binary file, too large to show as hex", state.message)
+	state.message = ""
+	assert.Equal("raw", renderScreen(state).Text(state.height-1))
+
+	// neither the encoding nor the line endings can change, as the file isn't decoded.
+	state = typeNotation(state, `M-x "set-encoding" <RET>`)
+	assert.Nil(state.prompt)
+	assert.Equal("can't change the encoding of a large file", state.message)
+	state = typeNotation(state, `M-x "line-endings-crlf" <RET>`)
+	assert.Equal("can't convert line endings of a large file", state.message)
+
+	state = typeNotation(state, `C-x C-s`)
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("\xff\xfeo\x00n\x00e\x00\r\x00\n\x00", string(written))
+}
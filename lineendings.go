@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// lineEnding is the newline rows are written with; buffers always split rows on `\n`.
+type lineEnding int
+
+const (
+	lineEndingLF lineEnding = iota
+	lineEndingCRLF
+)
+
+func (le lineEnding) String() string {
+	if le == lineEndingCRLF {
+		return "CRLF"
+	}
+	return "LF"
+}
+
+var crlf = []byte("\r\n")
+
+// detectLineEnding returns CRLF if every newline in text follows a carriage return.
+// Text with both is left with the carriage returns in the rows, and is shown as mixed.
+func detectLineEnding(text []byte) lineEnding {
+	crlfs := bytes.Count(text, crlf)
+	if crlfs > 0 && crlfs == bytes.Count(text, []byte{byteNewLine}) {
+		return lineEndingCRLF
+	}
+	return lineEndingLF
+}
+
+// MixedLineEndings returns if any rows still end in a carriage return, which is left
+// when a file has both kinds of line ending.
+func (es editorState) MixedLineEndings() bool {
+	for y := 0; y < len(es.buffer)-1; y++ {
+		if row := es.buffer[y]; len(row) > 0 && row[len(row)-1] == ANSI.cr {
+			return true
+		}
+	}
+	return false
+}
+
+// ConvertLineEndings sets the line ending the file is saved with, removing carriage returns left at the ends of rows.
+func (es editorState) ConvertLineEndings(le lineEnding) editorState {
+	switch {
+	case es.hex.enabled: // the carriage returns are bytes like any other
+		es.message = "can't convert line endings in hex mode"
+		return es
+	case es.large != nil: // converting walks every row
+		es.message = "can't convert line endings of a large file"
+		return es
+	}
+	for y := 0; y < len(es.buffer)-1; y++ {
+		if row := es.buffer[y]; len(row) > 0 && row[len(row)-1] == ANSI.cr {
+			es.buffer = es.buffer.TrimRowAt(y, len(row)-1)
+		}
+	}
+	es.cursor = es.buffer.Clamp(es.cursor)
+	es.lineEnding = le
+	es.message = fmt.Sprintf("will save with %s line endings", le)
+	return es
+}
+
+// encodeLineEndings writes the rows of a buffer joined by its line ending.
+func (es editorState) encodeLineEndings() []byte {
+	if es.lineEnding == lineEndingCRLF {
+		return bytes.Join(es.buffer, crlf)
+	}
+	return es.buffer.Bytes()
+}
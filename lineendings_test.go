@@ -0,0 +1,87 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func TestCRLFFile(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "windows.txt")
+	assert.Nil(os.WriteFile(path, []byte("one\r\ntwo\r\n"), 0644))
+	state, err := loadFile(path)
+	assert.Nil(err)
+	assert.Equal(lineEndingCRLF, state.lineEnding)
+	assert.Equal("one\ntwo\n", string(state.buffer.Bytes()))
+	assert.False(state.MixedLineEndings())
+
+	state = typeNotation(state, `C-e "!" C-x C-s`)
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("one!\r\ntwo\r\n", string(written))
+
+	state = typeNotation(state, `M-x "line-endings-lf" <RET> C-x C-s`)
+	written, err = os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("one!\ntwo\n", string(written))
+}
+
+func TestMixedLineEndings(t *testing.T) {
+	assert := assert.New(t)
+
+	state, err := stateFromBytes([]byte("one\r\ntwo\nthree\r\n"))
+	assert.Nil(err)
+	assert.Equal(lineEndingLF, state.lineEnding)
+	assert.True(state.MixedLineEndings())
+
+	vt := renderScreen(state.Resize(20, 4))
+	assert.Equal("one^M", vt.Text(0))
+	assert.Equal("two", vt.Text(1))
+	assert.Equal("mixed line endings", vt.Text(3))
+
+	state = state.ConvertLineEndings(lineEndingCRLF)
+	assert.False(state.MixedLineEndings())
+	assert.Equal("one\ntwo\nthree\n", string(state.buffer.Bytes()))
+	assert.Equal("one\r\ntwo\r\nthree\r\n", string(state.encodeLineEndings()))
+}
+
+func TestConvertLineEndingsIsAChange(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "unix.txt")
+	assert.Nil(os.WriteFile(path, []byte("one\ntwo\n"), 0644))
+	state, err := loadFile(path)
+	assert.Nil(err)
+
+	// the rows don't change, but the file does.
+	state = typeNotation(state, `M-x "line-endings-crlf" <RET>`)
+	assert.Equal(lineEndingCRLF, state.lineEnding)
+	assert.True(state.Modified())
+	assert.True(state.locked)
+
+	undone := typeNotation(state, `C-_`)
+	assert.Equal(lineEndingLF, undone.lineEnding)
+	assert.False(undone.Modified())
+
+	state = typeNotation(state, `C-x C-s`)
+	assert.False(state.Modified())
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("one\r\ntwo\r\n", string(written))
+}
+
+func TestConvertLineEndingsRefusedInHexMode(t *testing.T) {
+	assert := assert.New(t)
+
+	state, err := stateFromBytes([]byte("\x00\r\n\x01\n"))
+	assert.Nil(err)
+	assert.True(state.hex.enabled)
+	state = state.ConvertLineEndings(lineEndingCRLF)
+	assert.Equal("can't convert line endings in hex mode", state.message)
+	assert.Equal(lineEndingLF, state.lineEnding)
+	assert.Equal("\x00\r\n\x01\n", string(state.buffer.Bytes()))
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// lockPath returns the path of the lock file for a file, `.#name` next to it like emacs.
+func lockPath(path string) string {
+	dir, name := filepath.Split(path)
+	return filepath.Join(dir, ".#"+name)
+}
+
+// lockOwner returns who we are in a lock file, `user@host.pid`.
+func lockOwner() string {
+	name := os.Getenv("USER")
+	if current, err := user.Current(); err == nil {
+		name = current.Username
+	}
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s@%s.%d", name, host, os.Getpid())
+}
+
+// readLock returns the owner of a lock file, or the empty string if the file isn't locked.
+func readLock(path string) string {
+	lock := lockPath(path)
+	if owner, err := os.Readlink(lock); err == nil {
+		return owner
+	}
+	if contents, err := os.ReadFile(lock); err == nil {
+		return strings.TrimSpace(string(contents))
+	}
+	return ""
+}
+
+// lockIsStale returns if a lock was left behind by a process on this host that has exited.
+func lockIsStale(owner string) bool {
+	at := strings.LastIndexByte(owner, '@')
+	dot := strings.LastIndexByte(owner, '.')
+	if at < 0 || dot < at {
+		return false
+	}
+	host, _ := os.Hostname()
+	if owner[at+1:dot] != host {
+		return false
+	}
+	pid, err := strconv.Atoi(strings.SplitN(owner[dot+1:], ":", 2)[0])
+	if err != nil {
+		return false
+	}
+	return syscall.Kill(pid, 0) == syscall.ESRCH
+}
+
+// takeLock makes our lock file, returning who holds the lock if someone already does.
+// The lock is created exclusively, so of two editors taking it at once only one gets it.
+func takeLock(path string) (string, error) {
+	lock := lockPath(path)
+	// the lock is a symlink, so it can be made atomically and read without opening it.
+	err := os.Symlink(lockOwner(), lock)
+	if err != nil && !os.IsExist(err) {
+		// a file system without symlinks gets a file, made just as exclusively.
+		var f *os.File
+		if f, err = os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644); err == nil {
+			_, err = f.WriteString(lockOwner())
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+		}
+	}
+	if os.IsExist(err) {
+		return readLock(path), nil
+	}
+	return "", err
+}
+
+// replaceLock takes the lock over from its owner, as long as it's still theirs.
+func replaceLock(path, owner string) (string, error) {
+	if current := readLock(path); current != owner {
+		return current, nil
+	}
+	if err := os.Remove(lockPath(path)); err != nil && !os.IsNotExist(err) {
+		return "", err
+	}
+	return takeLock(path)
+}
+
+// removeLock removes our lock file, leaving anyone else's alone.
+func removeLock(path string) {
+	if readLock(path) == lockOwner() {
+		os.Remove(lockPath(path))
+	}
+}
+
+// Lock takes the lock on the file as the buffer is first modified. If someone else
+// holds it, it asks whether to steal the lock, open the file read-only or cancel the edit.
+func (es editorState) Lock(unmodified editorState) editorState {
+	owner, err := takeLock(es.path)
+	if err == nil && owner != "" && owner != lockOwner() && lockIsStale(owner) {
+		owner, err = replaceLock(es.path, owner)
+	}
+	if err != nil || owner == "" || owner == lockOwner() {
+		if err != nil {
+			// a directory we can't write to can't be locked, but that's no reason to stop editing.
+			es.message = fmt.Sprintf("could not lock %s: %v", es.path, err)
+		}
+		es.locked = true
+		return es
+	}
+
+	edited := es
+	label := fmt.Sprintf("%s is locked by %s: (s)teal the lock, open (r)ead-only, (c)ancel? ", es.path, owner)
+	return unmodified.Choose(label, "src", func(es editorState, choice byte) editorState {
+		switch choice {
+		case 's':
+			current, err := replaceLock(edited.path, owner)
+			switch {
+			case err != nil:
+				edited.message = err.Error()
+			case current != "" && current != lockOwner():
+				es.message = fmt.Sprintf("%s is now locked by %s", es.path, current)
+				return es
+			}
+			edited.locked = true
+			return edited
+		case 'r':
+			es.readOnly = true
+			es.message = "read-only"
+		}
+		return es
+	})
+}
+
+// Unlock releases the lock on the file, if we hold it.
+func (es editorState) Unlock() editorState {
+	if es.locked {
+		removeLock(es.path)
+		es.locked = false
+	}
+	return es
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func TestLockTakenOnFirstChange(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	assert.Nil(os.WriteFile(path, []byte("one\n"), 0644))
+	state, err := loadFile(path)
+	assert.Nil(err)
+	assert.Empty(readLock(path))
+
+	state = typeNotation(state, `"x"`)
+	assert.True(state.locked)
+	assert.Equal(lockOwner(), readLock(path))
+
+	state = state.Save()
+	assert.False(state.locked)
+	assert.Empty(readLock(path))
+}
+
+func TestLockHeldByOther(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	assert.Nil(os.WriteFile(path, []byte("one\n"), 0644))
+	assert.Nil(os.Symlink("someone@elsewhere.1", lockPath(path)))
+	state, err := loadFile(path)
+	assert.Nil(err)
+
+	readOnly := typeNotation(state, `"x" r "y"`)
+	assert.True(readOnly.readOnly)
+	assert.Equal("one\n", string(readOnly.buffer.Bytes()))
+	assert.Equal("someone@elsewhere.1", readLock(path))
+
+	cancelled := typeNotation(state, `"x" c`)
+	assert.False(cancelled.readOnly)
+	assert.Equal("one\n", string(cancelled.buffer.Bytes()))
+
+	stolen := typeNotation(state, `"x" s`)
+	assert.True(stolen.locked)
+	assert.Equal("xone\n", string(stolen.buffer.Bytes()))
+	assert.Equal(lockOwner(), readLock(path))
+}
+
+func TestTakeLockIsExclusive(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	assert.Nil(os.Symlink("someone@elsewhere.1", lockPath(path)))
+	owner, err := takeLock(path)
+	assert.Nil(err)
+	assert.Equal("someone@elsewhere.1", owner)
+	// a live lock isn't replaced once someone else has taken it over.
+	owner, err = replaceLock(path, "another@elsewhere.2")
+	assert.Nil(err)
+	assert.Equal("someone@elsewhere.1", owner)
+	assert.Equal("someone@elsewhere.1", readLock(path))
+
+	owner, err = replaceLock(path, "someone@elsewhere.1")
+	assert.Nil(err)
+	assert.Empty(owner)
+	assert.Equal(lockOwner(), readLock(path))
+}
+
+func TestSaveHooksDontRelock(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	assert.Nil(os.WriteFile(path, []byte("one  \n"), 0644))
+	state, err := loadFile(path)
+	assert.Nil(err)
+	state.settings.trimTrailingWhitespace = true
+
+	state = typeNotation(state, `"x" C-x C-s`)
+	assert.Equal("xone\n", string(state.buffer.Bytes()))
+	assert.False(state.locked)
+	assert.Empty(readLock(path))
+}
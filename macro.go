@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// macroState is the state of keyboard macro recording.
+type macroState struct {
+	recording bool
+	// keys are the keys recorded so far.
+	keys []key
+	// last is the last macro recorded.
+	last []key
+	// depth is how many macros are playing, each run by the one before it.
+	depth int
+	// stopped is set when macros nest too deeply, and stops every macro playing.
+	stopped bool
+}
+
+// maxMacroDepth is how deeply macros can run each other, which stops a macro that runs itself.
+const maxMacroDepth = 64
+
+// StartMacro starts recording keys.
+func (es editorState) StartMacro() editorState {
+	if es.macro.recording {
+		es.message = "already defining a macro"
+		return es
+	}
+	es.macro.recording = true
+	es.macro.keys = nil
+	es.message = "defining macro..."
+	return es
+}
+
+// StopMacro stops recording keys, keeping them as the last macro.
+func (es editorState) StopMacro() editorState {
+	if !es.macro.recording {
+		es.message = "not defining a macro"
+		return es
+	}
+	keys := es.macro.keys
+	// the prefix of the key that stopped recording was recorded.
+	if len(keys) > 0 && keys[len(keys)-1] == (key{b: ANSI.can}) {
+		keys = keys[0 : len(keys)-1]
+	}
+	es.macro = macroState{last: keys, depth: es.macro.depth, stopped: es.macro.stopped}
+	es.message = "macro defined"
+	return es
+}
+
+// recordKey adds a key to the macro being recorded.
+// keys that can't be written in key notation aren't recorded, so a macro plays the same once it's saved.
+func (es editorState) recordKey(k key) editorState {
+	if !k.notated() {
+		return es
+	}
+	// copy so earlier states keep their keys.
+	keys := make([]key, len(es.macro.keys), len(es.macro.keys)+1)
+	copy(keys, es.macro.keys)
+	es.macro.keys = append(keys, k)
+	return es
+}
+
+// playMacro replays keys through the key handlers a number of times.
+func playMacro(keys []key, count int, state editorState) (editorState, error) {
+	if len(keys) == 0 {
+		state.message = "no macro defined"
+		return state, nil
+	}
+	if state.macro.recording {
+		state.message = "can't play a macro while defining one"
+		return state, nil
+	}
+
+	if state.macro.depth >= maxMacroDepth {
+		state.macro.stopped = true
+		state.message = "macros nested too deeply"
+		return state, nil
+	}
+
+	state.macro.depth++
+	var err error
+play:
+	for x := 0; x < count; x++ {
+		for _, k := range keys {
+			state, err = processKey(k, state)
+			if err != nil || state.macro.stopped {
+				break play
+			}
+		}
+	}
+	state.macro.depth--
+	if state.macro.depth == 0 {
+		state.macro.stopped = false
+	}
+	return state, err
+}
+
+// PromptNameMacro asks for a name for the last macro and saves it as a command.
+func (es editorState) PromptNameMacro() editorState {
+	if len(es.macro.last) == 0 {
+		es.message = "no macro defined"
+		return es
+	}
+	return es.Prompt("Name for last macro: ", func(es editorState, name string) editorState {
+		name = strings.TrimSpace(name)
+		if name == "" || strings.ContainsAny(name, " \t") {
+			es.message = fmt.Sprintf("invalid macro name: %q", name)
+			return es
+		}
+
+		macros := make(map[string][]key, len(es.macros)+1)
+		for existing, keys := range es.macros {
+			macros[existing] = keys
+		}
+		macros[name] = es.macro.last
+		es.macros = macros
+
+		if err := saveMacros(macrosPath(), macros); err != nil {
+			es.message = err.Error()
+			return es
+		}
+		es.message = fmt.Sprintf("saved macro %s", name)
+		return es
+	})
+}
+
+// PromptCommand asks for the name of a saved macro and plays it, or runs a built in command.
+func (es editorState) PromptCommand() editorState {
+	return es.Prompt("M-x ", func(es editorState, name string) editorState {
+		keys, ok := es.macros[strings.TrimSpace(name)]
+		if !ok {
+			if command, ok := builtinCommand(strings.TrimSpace(name)); ok {
+				return command(es)
+			}
+			es.message = fmt.Sprintf("no command named %q", name)
+			return es
+		}
+		played, err := playMacro(keys, 1, es)
+		if err == errQuit {
+			played.quit = true
+		}
+		return played
+	})
+}
+
+// builtinCommand returns the command with a name, for commands without a key of their own.
+func builtinCommand(name string) (func(editorState) editorState, bool) {
+	switch name {
+	case "hex-mode":
+		return editorState.ToggleHex, true
+	case "set-encoding":
+		return editorState.PromptEncoding, true
+	case "line-endings-lf":
+		return func(es editorState) editorState { return es.ConvertLineEndings(lineEndingLF) }, true
+	case "line-endings-crlf":
+		return func(es editorState) editorState { return es.ConvertLineEndings(lineEndingCRLF) }, true
+	case "find-file":
+		return editorState.FindFile, true
+	case "revert":
+		return editorState.Reload, true
+	}
+	return nil, false
+}
+
+// macrosPath returns the path of the file named macros are saved to.
+func macrosPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "editor", "macros")
+}
+
+// loadMacros reads named macros, one per line as the name then the keys in key notation.
+func loadMacros(path string) (map[string][]key, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return map[string][]key{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	macros := map[string][]key{}
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		name, notation := line, ""
+		if space := strings.IndexAny(line, " \t"); space >= 0 {
+			name, notation = line[0:space], line[space+1:]
+		}
+		keys, err := parseKeys(notation)
+		if err != nil {
+			return nil, fmt.Errorf("macro %s: %v", name, err)
+		}
+		macros[name] = keys
+	}
+	return macros, scanner.Err()
+}
+
+// saveMacros writes named macros in the format read by loadMacros.
+func saveMacros(path string, macros map[string][]key) error {
+	names := make([]string, 0, len(macros))
+	for name := range macros {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var contents strings.Builder
+	for _, name := range names {
+		fmt.Fprintf(&contents, "%s %s\n", name, formatKeys(macros[name]))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(contents.String()), 0644)
+}
@@ -0,0 +1,108 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func TestKeyNotation(t *testing.T) {
+	assert := assert.New(t)
+
+	keys, err := parseKeys(`C-a "hello world" M-f <Down> <RET> x C-x C-s M-<`)
+	assert.Nil(err)
+	assert.Len(keys, 19)
+	assert.Equal(key{b: ANSI.soh}, keys[0])
+	assert.Equal(key{b: ' '}, keys[6])
+	assert.Equal(key{b: 'f', meta: true}, keys[12])
+	assert.Equal(key{special: keyDown}, keys[13])
+	assert.Equal(key{b: ANSI.cr}, keys[14])
+	assert.Equal(key{b: ANSI.can}, keys[16])
+	assert.Equal(key{b: '<', meta: true}, keys[18])
+
+	assert.Equal(`C-a "hello world" M-f <Down> <RET> "x" C-x C-s M-<`, formatKeys(keys))
+
+	// spaces separate tokens, they're typed as <SPC> or quoted.
+	keys, _ = parseKeys(`a b`)
+	assert.Equal([]key{{b: 'a'}, {b: 'b'}}, keys)
+	keys, _ = parseKeys(`a <SPC> b`)
+	assert.Equal([]key{{b: 'a'}, {b: ' '}, {b: 'b'}}, keys)
+}
+
+func TestMacroRecordAndPlay(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("a\nb\nc")
+	keys, _ := parseKeys(`C-x ( C-e "!" C-n C-x )`)
+	for _, k := range keys {
+		state, _ = processKey(k, state)
+	}
+	assert.Equal("a!", string(state.buffer[0]))
+	assert.False(state.macro.recording)
+	assert.Equal(`C-e "!" C-n`, formatKeys(state.macro.last))
+
+	keys, _ = parseKeys(`C-x e e`)
+	for _, k := range keys {
+		state, _ = processKey(k, state)
+	}
+	assert.Equal("b!", string(state.buffer[1]))
+	assert.Equal("c!", string(state.buffer[2]))
+}
+
+func TestMacroRecordsOnlyNotatedKeys(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("one\ntwo")
+	state = typeNotation(state, `C-x (`)
+	for _, k := range []key{{special: keyMouse, mouse: mouseEvent{action: mousePress, row: 1, col: 1}}, {special: keyUnknown}} {
+		state, _ = processKey(k, state)
+	}
+	state = typeNotation(state, `<Down> "\xc3\xa9" M-f C-x )`)
+	assert.Equal(`<Down> "é" M-f`, formatKeys(state.macro.last))
+
+	// the macro plays the same once it's saved and loaded.
+	path := filepath.Join(t.TempDir(), "macros")
+	assert.Nil(saveMacros(path, map[string][]key{"down": state.macro.last}))
+	macros, err := loadMacros(path)
+	assert.Nil(err)
+	assert.Equal(state.macro.last, macros["down"])
+}
+
+func TestMacroRunningItself(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("")
+	keys, _ := parseKeys(`"a" M-x "again" <RET> "b"`)
+	state.macros = map[string][]key{"again": keys}
+	state = typeNotation(state, `M-x "again" <RET>`)
+	assert.Equal(strings.Repeat("a", maxMacroDepth), string(state.buffer.Bytes()))
+	assert.Equal("macros nested too deeply", state.message)
+	assert.Equal(0, state.macro.depth)
+	assert.False(state.macro.stopped)
+
+	// macros can still run each other, as long as they end.
+	keys, _ = parseKeys(`M-x "again" <RET>`)
+	state = stateFromString("")
+	state.macros = map[string][]key{"twice": keys, "again": {{b: 'a'}}}
+	state = typeNotation(state, `M-x "twice" <RET> M-x "twice" <RET>`)
+	assert.Equal("aa", string(state.buffer.Bytes()))
+}
+
+func TestMacrosSaveAndLoad(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "macros")
+	keys, _ := parseKeys(`C-a "// " C-n`)
+	assert.Nil(saveMacros(path, map[string][]key{"comment": keys}))
+
+	contents, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("comment C-a \"// \" C-n\n", string(contents))
+
+	macros, err := loadMacros(path)
+	assert.Nil(err)
+	assert.Equal(keys, macros["comment"])
+}
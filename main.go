@@ -1,11 +1,18 @@
 package main
 
 import (
+	"bufio"
 	"bytes"
 	"errors"
+	"flag"
+	"fmt"
 	"io"
 	"log"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
+	"unicode/utf8"
 )
 
 const (
@@ -13,10 +20,257 @@ const (
 	byteTab     = byte('\t')
 )
 
+// errQuit is returned by the key handlers when the editor should exit.
+var errQuit = errors.New("should exit")
+
+var (
+	flagKeymap         = flag.String("keymap", "emacs", "the key bindings to use, emacs or vi")
+	flagWordChars      = flag.String("word-chars", defaultWordChars, "characters other than letters and digits that are part of a word")
+	flagFormatOnSave   = flag.Bool("format-on-save", false, "run go files through gofmt when saving")
+	flagTrimWhitespace = flag.Bool("trim-trailing-whitespace", false, "remove whitespace from the ends of rows when saving")
+	flagTrimModified   = flag.Bool("trim-modified-lines", false, "remove whitespace from the ends of only the rows changed since the last save")
+	flagFinalNewline   = flag.Bool("final-newline", false, "end files with exactly one newline when saving")
+	flagShowWhitespace = flag.Bool("show-trailing-whitespace", false, "highlight whitespace at the ends of rows")
+	flagAutosave       = flag.Duration("autosave", 30*time.Second, "how often unsaved changes are written to the recovery file")
+	flagCheckDisk      = flag.Duration("check-disk", 2*time.Second, "how often to look for changes to the file made by something else")
+	flagBatch          = flag.String("batch", "", "replay the keys in this script over the file without a terminal, then write the file")
+	flagStdout         = flag.Bool("stdout", false, "with -batch, write the result to stdout rather than to the file")
+	flagRecord         = flag.String("record", "", "record the input and screen size to this session file, for bug reports")
+	flagReplay         = flag.String("replay", "", "replay a recorded session over the file, then print the screen and buffer")
+)
+
+func processKey(k key, state editorState) (editorState, error) {
+	state.message = ""
+
+	previous := state
+
+	var err error
+	switch {
+	case state.prompt != nil:
+		state = processPromptKey(k, state)
+	case state.finder != nil:
+		state = processFinderKey(k, state)
+	case state.viewing != nil:
+		state, err = processViewKey(k, state)
+	case state.hex.enabled:
+		state, err = processHexKey(k, state)
+	case k.special == keyMouse:
+		state = state.Mouse(k.mouse)
+	case k.special == keyPaste:
+		// pastes are always inserted as they are, without running key bindings.
+		state.prefix = 0
+		state.arg = prefixArg{}
+		state = state.Paste([]byte(k.text))
+	case state.vi.enabled:
+		state, err = processViKey(k, state)
+	default:
+		state, err = processEmacsKey(k, state)
+	}
+	if state.quit {
+		err = errQuit
+	}
+	if state.macro.recording && previous.macro.recording {
+		state = state.recordKey(k)
+	}
+
+	// opening another file starts its own history, and it's locked on its first change.
+	opened := state.justOpened
+	state.justOpened = false
+	changed := !opened && (!state.sameText(previous) || state.lineEnding != previous.lineEnding)
+	if changed && state.readOnly {
+		state = previous
+		state.message = "buffer is read-only"
+		changed = false
+	}
+	// save hooks change the buffer too, but leave it as it's saved.
+	if changed && state.path != "" && !state.locked && state.Modified() {
+		state = state.Lock(previous)
+		changed = !state.sameText(previous) || state.lineEnding != previous.lineEnding
+	}
+
+	// every change to the buffer can be undone, apart from undoing itself.
+	// in vi the whole of an insert is undone at once.
+	if changed {
+		state.selecting = false
+	}
+	enteredInsert := state.vi.mode == viInsert && previous.vi.mode != viInsert
+	if (changed || enteredInsert) && state.undo == previous.undo && previous.vi.mode != viInsert {
+		previous.message = ""
+		previous.prefix = 0
+		previous.prompt = nil
+		previous.vi = previous.vi.reset()
+		state.undo = &previous
+	}
+	// moving through a large file takes the rows around the cursor into the buffer.
+	if state.large != nil {
+		state = state.slideLargeWindow()
+	}
+	return state.ScrollToCursor(), err
+}
+
+// processEmacsKey handles keys for the default, emacs like, keymap.
+func processEmacsKey(k key, state editorState) (editorState, error) {
+	if arg, ok := state.arg.Update(k, state.prefix); ok {
+		state.arg = arg
+		state.prefix = 0
+		return state, nil
+	}
+	if state.prefix == 0 && k.special == keyNone && !k.meta && (k.b == ANSI.can || k.b == ANSI.esc) {
+		// the argument is kept for the command after the prefix.
+		return processEmacsCommand(k, state)
+	}
+
+	count := 1
+	if state.arg.active {
+		count = state.arg.Count()
+		state.arg = prefixArg{}
+
+		// some commands use the count rather than being repeated.
+		if state.prefix == 0 && k == (key{b: ANSI.vt}) {
+			return state.KillLines(count), nil
+		}
+		if (state.prefix == 0 && k == (key{b: 'g', meta: true})) || (state.prefix == ANSI.esc && k == (key{b: 'g'})) {
+			state.prefix = 0
+			return state.GotoLine(count), nil
+		}
+	}
+
+	var err error
+	prefix := state.prefix
+	for x := 0; x < count; x++ {
+		state.prefix = prefix
+		state, err = processEmacsCommand(k, state)
+		if err != nil || state.prompt != nil {
+			break
+		}
+	}
+	return state, err
+}
+
+// processEmacsCommand runs the command bound to a key in the emacs keymap.
+func processEmacsCommand(k key, state editorState) (editorState, error) {
+	switch {
+	case state.prefix != 0:
+		prefix := state.prefix
+		state.prefix = 0
+		return processPrefixKey(prefix, k, state)
+	case k.special != keyNone:
+		return processSpecialKey(k.special, state), nil
+	case k.meta:
+		return processMetaKey(k.b, state), nil
+	default:
+		return processSingleInput(k.b, state)
+	}
+}
+
+// processPrefixKey handles the key after a prefix key like C-x.
+func processPrefixKey(prefix byte, k key, state editorState) (editorState, error) {
+	switch prefix {
+	case ANSI.esc: // escape typed on its own makes the next key a meta key
+		if k.special != keyNone {
+			return processSpecialKey(k.special, state), nil
+		}
+		return processMetaKey(k.b, state), nil
+	case 'e': // after playing a macro, e plays it again
+		if k == (key{b: 'e'}) {
+			return playLastMacro(state)
+		}
+		return processEmacsKey(k, state)
+	}
+
+	if k.special != keyNone || k.meta {
+		return state, nil
+	}
+	switch k.b {
+	case ANSI.dc3: // C-x C-s
+		return state.Save(), nil
+	case 'u':
+		return state.Undo(), nil
+	case 'f':
+		return state.Format(), nil
+	case '(':
+		return state.StartMacro(), nil
+	case ')':
+		return state.StopMacro(), nil
+	case 'e':
+		return playLastMacro(state)
+	case ANSI.vt: // C-x C-k
+		return state.PromptNameMacro(), nil
+	case ANSI.cr: // C-x RET
+		return state.PromptEncoding(), nil
+	case ANSI.ack: // C-x C-f
+		return state.FindFile(), nil
+	default:
+		return state, nil
+	}
+}
+
+func playLastMacro(state editorState) (editorState, error) {
+	state, err := playMacro(state.macro.last, 1, state)
+	if err == nil && len(state.macro.last) > 0 && !state.macro.recording {
+		state.prefix = 'e'
+	}
+	return state, err
+}
+
+func processSpecialKey(k specialKey, state editorState) editorState {
+	switch k {
+	case keyUp:
+		return state.MoveUp()
+	case keyDown:
+		return state.MoveDown()
+	case keyRight:
+		return state.MoveRight()
+	case keyLeft:
+		return state.MoveLeft()
+	case keyHome:
+		return state.MoveToBeginningOfLine()
+	case keyEnd:
+		return state.MoveToEndOfLine()
+	case keyDelete:
+		return state.DeleteForward()
+	default:
+		return state
+	}
+}
+
+func processMetaKey(b byte, state editorState) editorState {
+	switch b {
+	case 'f':
+		return state.MoveWordForward()
+	case 'b':
+		return state.MoveWordBackward()
+	case '}':
+		return state.MoveParagraphForward()
+	case '{':
+		return state.MoveParagraphBackward()
+	case '<':
+		return state.MoveToBeginningOfBuffer()
+	case '>':
+		return state.MoveToEndOfBuffer()
+	case 'g':
+		return state.PromptGotoLine()
+	case 'd':
+		return state.DeleteWordForward()
+	case ANSI.bs, ANSI.del:
+		return state.DeleteWordBackward()
+	case 'k':
+		return state.KillWholeLine()
+	case ']':
+		return state.JumpToMatchingBracket()
+	case 'x':
+		return state.PromptCommand()
+	case 'w':
+		return state.CopyRegion()
+	default:
+		return state
+	}
+}
+
 func processSingleInput(b byte, state editorState) (editorState, error) {
 	switch b {
 	case ANSI.etx:
-		return state, errors.New("should exit")
+		return state, errQuit
 	case ANSI.vt:
 		return state.TrimLine(), nil
 	case ANSI.dle:
@@ -33,6 +287,20 @@ func processSingleInput(b byte, state editorState) (editorState, error) {
 		return state.MoveToEndOfLine(), nil
 	case ANSI.bs, ANSI.del:
 		return state.Backspace(), nil
+	case ANSI.eot:
+		return state.DeleteForward(), nil
+	case ANSI.can, ANSI.esc:
+		state.prefix = b
+		return state, nil
+	case ANSI.us: // C-_ and C-/
+		return state.Undo(), nil
+	case ANSI.bel:
+		state.selecting = false
+		return state, nil
+	case ANSI.etb:
+		return state.KillRegion(), nil
+	case ANSI.em:
+		return state.Yank(), nil
 	case ANSI.cr, ANSI.lf:
 		return state.Newline(), nil
 	default:
@@ -40,55 +308,172 @@ func processSingleInput(b byte, state editorState) (editorState, error) {
 	}
 }
 
-func render(tty *os.File, state editorState) (err error) {
+// render draws the state to a terminal, which is the tty or a virtualTerminal in tests.
+func render(tty io.Writer, state editorState) (err error) {
 	tty.Write(ANSI.ClearScreen())
 	tty.Write(ANSI.MoveCursor(0, 0))
 	tty.Write(ANSI.colorReset)
 
-	c := make([]byte, 1)
-	var cursorRowTabs int
-	for row := 0; row < len(state.buffer); row++ {
-		tty.Write(ANSI.MoveCursor(row+1, 0))
-		for col := 0; col < len(state.buffer[row]); col++ {
-			c[0] = state.buffer[row][col]
-			switch c[0] {
-			case ANSI.tab:
-				tty.Write(ANSI.Spaces(4))
-				if row == state.cursor.row {
-					cursorRowTabs++
-				}
-			default:
-				tty.Write(c)
-			}
+	var unbalanced bool
+	if state.hex.enabled {
+		renderHex(tty, state)
+	} else {
+		unbalanced = renderText(tty, state)
+	}
+
+	if state.height > 0 {
+		if state.finder != nil {
+			renderFinder(tty, state)
+			return
 		}
-		if row != state.cursor.row {
-			cursorRowTabs = 0
+		tty.Write(ANSI.MoveCursor(state.height, 0))
+		if state.prompt != nil {
+			status := state.prompt.label + string(state.prompt.input)
+			tty.Write([]byte(status))
+			tty.Write(ANSI.MoveCursor(state.height, len(status)+1))
+			return
+		}
+		switch {
+		case state.arg.active:
+			tty.Write([]byte(state.arg.String()))
+		case state.prefix == ANSI.can:
+			tty.Write([]byte("C-x-"))
+		case state.macro.recording && state.message == "":
+			tty.Write([]byte("defining macro..."))
+		case state.message != "":
+			tty.Write([]byte(state.message))
+		case unbalanced:
+			tty.Write(ANSI.colorWarning)
+			tty.Write([]byte("unbalanced bracket"))
+			tty.Write(ANSI.colorReset)
+		case state.diskChanged:
+			tty.Write(ANSI.colorWarning)
+			tty.Write([]byte("file changed on disk"))
+			tty.Write(ANSI.colorReset)
+		case state.large == nil && !state.hex.enabled && state.MixedLineEndings():
+			tty.Write(ANSI.colorWarning)
+			tty.Write([]byte("mixed line endings"))
+			tty.Write(ANSI.colorReset)
+		case state.readOnly:
+			tty.Write([]byte("read-only"))
+		case state.large != nil: // written back byte for byte, whatever its encoding and line endings
+			tty.Write([]byte("raw"))
+		case state.encoding != encodingUTF8 || state.lineEnding != lineEndingLF:
+			tty.Write([]byte(state.encoding.String() + " " + state.lineEnding.String()))
+		case state.vi.enabled:
+			tty.Write([]byte(state.vi.mode.String()))
 		}
 	}
 
-	var extraTabSpaces int
-	if cursorRowTabs > 0 {
-		extraTabSpaces = (cursorRowTabs * 3)
+	if state.hex.enabled {
+		row, col := state.hexScreenCursor()
+		tty.Write(ANSI.MoveCursor(row, col))
+		return
 	}
-
-	tty.Write(ANSI.MoveCursor(state.cursor.row+1, state.cursor.col+1+extraTabSpaces))
+	tty.Write(ANSI.MoveCursor(state.cursor.row-state.scroll+1, state.buffer.VisualColumn(state.cursor.row, state.cursor.col, state.settings.tabWidth)-state.scrollLeft+1))
 	return
 }
 
+// renderText draws the rows of the buffer on screen, returning if the cursor is on an unbalanced bracket.
+func renderText(tty io.Writer, state editorState) (unbalanced bool) {
+	lastRow := len(state.buffer)
+	if rows := state.TextHeight(); rows > 0 && state.scroll+rows < lastRow {
+		lastRow = state.scroll + rows
+	}
+
+	bracketAt, bracketMatch, bracketMatched, onBracket := state.BracketAtCursor()
+	selectionFrom, selectionTo, hasSelection := state.Selection()
+
+	var glyphText []byte
+	for row := state.scroll; row < lastRow; row++ {
+		tty.Write(ANSI.MoveCursor(row-state.scroll+1, 0))
+		trailing := len(state.buffer[row])
+		// whitespace just typed at the cursor isn't trailing yet.
+		if state.settings.showTrailingWhitespace && !(row == state.cursor.row && state.cursor.col == len(state.buffer[row])) {
+			trailing = len(trimTrailingWhitespace(state.buffer[row]))
+		}
+		var visual int
+		for col := 0; col < len(state.buffer[row]); col++ {
+			glyph := glyphWidth(state.buffer[row][col], state.settings.tabWidth)
+			// long rows are cut off, rather than wrapping over the rows below, and scrolled
+			// along with the cursor.
+			if state.width > 0 && visual+glyph > state.scrollLeft+state.width {
+				break
+			}
+			tooLong := state.settings.maxLineLength > 0 && visual >= state.settings.maxLineLength
+			hidden := state.scrollLeft - visual // the columns of the glyph left of the screen
+			visual += glyph
+			if hidden >= glyph {
+				continue
+			}
+
+			at := cursor{row: row, col: col}
+			selected := hasSelection && !cursorAfter(selectionFrom, at) && cursorAfter(selectionTo, at)
+			bracket := onBracket && (at == bracketAt || (bracketMatched && at == bracketMatch))
+			reverse := selected || bracket && bracketMatched
+			// an unmatched bracket, trailing whitespace and text past the line length are warnings.
+			highlight := reverse || bracket || col >= trailing || tooLong
+			if reverse {
+				tty.Write(ANSI.colorReverse)
+			} else if highlight {
+				tty.Write(ANSI.colorWarning)
+			}
+
+			glyphText = appendGlyph(glyphText[:0], state.buffer[row][col], state.settings.tabWidth)
+			if hidden > 0 {
+				glyphText = glyphText[hidden:]
+			}
+			tty.Write(glyphText)
+
+			if highlight {
+				tty.Write(ANSI.colorReset)
+			}
+		}
+	}
+	return onBracket && !bracketMatched
+}
+
+// appendGlyph appends how a byte is drawn: tabs as spaces, and control bytes in caret notation.
+func appendGlyph(dst []byte, c byte, tabWidth int) []byte {
+	switch glyph := glyphWidth(c, tabWidth); {
+	case c == byteTab:
+		return append(dst, bytes.Repeat([]byte{' '}, glyph)...)
+	case glyph == 2:
+		return append(dst, '^', c^0x40)
+	}
+	return append(dst, c)
+}
+
+// initTerm opens the terminal itself rather than using stdin and stdout, which may be pipes.
 func initTerm() (*Termios, *os.File) {
-	tty := os.Stdout
-	initialSettings, err := MakeRaw(os.Stdout.Fd())
+	tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	initialSettings, err := MakeRaw(tty.Fd())
 	if err != nil {
 		log.Fatal(err)
 	}
 
 	tty.Write(ANSI.ClearScreen())
 	tty.Write(ANSI.MoveCursor(0, 0))
+	tty.Write(ANSI.pasteOn)
+	tty.Write(ANSI.mouseOn)
 
 	return initialSettings, tty
 }
 
+func termSize(tty *os.File) (width, height int) {
+	size, err := GetWinSize(tty.Fd())
+	if err != nil {
+		return 80, 24
+	}
+	return int(size.Cols), int(size.Rows)
+}
+
 func restoreTerm(initialSettings *Termios, tty *os.File) {
+	tty.Write(ANSI.mouseOff)
+	tty.Write(ANSI.pasteOff)
 	err := TcSetAttr(tty.Fd(), initialSettings)
 	tty.Close()
 	if err != nil {
@@ -96,31 +481,121 @@ func restoreTerm(initialSettings *Termios, tty *os.File) {
 	}
 }
 
-func stateFromFile(path string) (editorState, error) {
+// loadFile reads a file into a buffer, which is empty if the file doesn't exist yet.
+func loadFile(path string) (editorState, error) {
+	var es editorState
 	f, err := os.Open(path)
+	if os.IsNotExist(err) { // a new file
+		es = newEditorState()
+	} else if err != nil {
+		return editorState{}, err
+	} else {
+		defer f.Close()
+		info, err := f.Stat()
+		if err != nil {
+			return editorState{}, err
+		}
+		if info.Size() > largeFileSize {
+			if es, err = stateFromMappedFile(f, info.Size()); err != nil {
+				return editorState{}, err
+			}
+		} else {
+			data, err := io.ReadAll(f)
+			if err != nil {
+				return editorState{}, err
+			}
+			if es, err = stateFromBytes(data); err != nil {
+				return editorState{}, err
+			}
+		}
+	}
+	es.path = path
+	es.settings = settingsForPath(path)
+	if config, err := loadEditorConfig(path); err != nil {
+		es.message = err.Error()
+	} else {
+		es = es.ApplyEditorConfig(config)
+	}
+	if es.disk, err = stampFile(path); err != nil {
+		return editorState{}, err
+	}
+	return es, nil
+}
+
+// stateFromStdin reads a document piped to stdin into a buffer without a path,
+// so saving it asks where to.
+func stateFromStdin(stdin io.Reader) (editorState, error) {
+	contents, err := io.ReadAll(stdin)
+	if err != nil {
+		return editorState{}, err
+	}
+	es, err := stateFromBytes(contents)
 	if err != nil {
 		return editorState{}, err
 	}
-	return stateFromReader(f), nil
+	if es.message == "" {
+		es.message = "read from stdin"
+	}
+	return es, nil
+}
+
+// stateFromBytes loads the contents of a file, decoding text to utf-8 and showing binaries in hex.
+func stateFromBytes(data []byte) (editorState, error) {
+	encoding := detectEncoding(data)
+	if encoding != encodingUTF16LE && encoding != encodingUTF16BE && isBinary(data) {
+		es := stateFromReader(bytes.NewReader(data))
+		es.hex.enabled = true
+		es.message = "binary file, showing hex"
+		return es, nil
+	}
+
+	text := decodeText(data, encoding)
+	ending := detectLineEnding(text)
+	if ending == lineEndingCRLF {
+		text = bytes.Replace(text, crlf, []byte{byteNewLine}, -1)
+	}
+	es := stateFromReader(bytes.NewReader(text))
+	es.encoding = encoding
+	es.lineEnding = ending
+	es.savedLineEnding = ending
+	if (encoding == encodingUTF16LE || encoding == encodingUTF16BE) && len(data)%2 != 0 {
+		es.message = fmt.Sprintf("%s text ends part way through a character, which is shown and saved as %q", encoding, utf8.RuneError)
+	}
+	return es, nil
 }
 
 func stateFromReader(reader io.ReaderAt) editorState {
 	es := editorState{
-		buffer: [][]byte{},
+		buffer:   [][]byte{},
+		settings: defaultSettings(),
 	}
 
 	var cursor int64
 	var readBuffer = make([]byte, 32)
 	var readErr error
 	var lineBuffer = bytes.NewBuffer([]byte{})
+	var endedWithNewline = true // an empty file is a single empty row
 	for readErr == nil {
 		lineBuffer.Reset()
+		start := cursor
 		cursor, readErr = readLine(reader, cursor, readBuffer, lineBuffer)
-		if readErr != nil {
-			continue
+		if readErr != nil && readErr != io.EOF {
+			break
+		}
+		// the last line may end at the eof without a newline.
+		if cursor > start {
+			// copy the line out, the line buffer is reused.
+			es.buffer = append(es.buffer, append([]byte{}, lineBuffer.Bytes()...))
+			endedWithNewline = int(cursor-start) > lineBuffer.Len()
 		}
-		es.buffer = append(es.buffer, lineBuffer.Bytes())
 	}
+	// a trailing newline starts a final empty row, so saving the rows joined by newlines
+	// writes the file back exactly.
+	if endedWithNewline {
+		es.buffer = append(es.buffer, []byte{})
+	}
+	es.saved = es.buffer
+	es.autosaved = es.buffer
 	return es
 }
 
@@ -173,45 +648,151 @@ func readLine(f io.ReaderAt, cursor int64, readBuffer []byte, lineBuffer *bytes.
 	return cursor, err
 }
 
-func main() {
-	var err error
-
+// loadState opens the file named on the command line, stdin for `-`, or else an empty buffer,
+// with the settings from the flags.
+func loadState(path string) (editorState, error) {
 	var state editorState
-	if len(os.Args) < 2 {
+	var err error
+	switch path {
+	case "":
 		state = newEditorState()
+	case "-":
+		state, err = stateFromStdin(os.Stdin)
+	default:
+		state, err = loadFile(path)
+	}
+	if err != nil {
+		return editorState{}, err
+	}
+
+	state.vi.enabled = *flagKeymap == "vi"
+	if state.macros, err = loadMacros(macrosPath()); err != nil {
+		return editorState{}, err
+	}
+	return applyFlags(state), nil
+}
+
+// applyFlags sets the settings given on the command line.
+func applyFlags(state editorState) editorState {
+	state.settings.wordChars = *flagWordChars
+	state.settings.formatOnSave = *flagFormatOnSave
+	state.settings.showTrailingWhitespace = *flagShowWhitespace
+
+	// flags given on the command line override the file's .editorconfig.
+	given := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	if given["trim-trailing-whitespace"] || given["trim-modified-lines"] {
+		state.settings.trimTrailingWhitespace = *flagTrimWhitespace || *flagTrimModified
+		state.settings.trimModifiedOnly = *flagTrimModified
+	}
+	if given["final-newline"] {
+		state.settings.finalNewline = *flagFinalNewline
+	}
+	return state
+}
+
+func main() {
+	flag.Parse()
+	if *flagKeymap != "emacs" && *flagKeymap != "vi" {
+		fmt.Fprintf(flag.CommandLine.Output(), "unknown keymap %q, use emacs or vi\n", *flagKeymap)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	state, err := loadState(flag.Arg(0))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *flagBatch != "" {
+		if err := runBatch(*flagBatch, state, *flagStdout, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if *flagReplay != "" {
+		if err := runReplay(*flagReplay, state, os.Stdout); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+	if flag.Arg(0) == "" {
+		state = state.CheckUntitledRecovery()
 	} else {
-		state, err = stateFromFile(os.Args[1])
-		if err != nil {
+		state = state.CheckRecovery()
+	}
+
+	var recording *os.File
+	if *flagRecord != "" {
+		if recording, err = os.Create(*flagRecord); err != nil {
 			log.Fatal(err)
 		}
+		defer recording.Close()
 	}
 
 	initialSettings, tty := initTerm()
 	defer restoreTerm(initialSettings, tty)
 
-	var c = make([]byte, 1)
-	for {
-		render(tty, state)
-
-		os.Stdin.Read(c)
-		if c[0] == 0x1b { // special key
-			os.Stdin.Read(c)
-			if c[0] == 0x5b { // escape
-				os.Stdin.Read(c)
-				switch c[0] { // arrow direction
-				case 0x43:
-					state = state.MoveRight()
-					continue
-				case 0x44:
-					state = state.MoveLeft()
-					continue
-				default:
-					continue
-				}
+	state = state.Resize(termSize(tty))
+
+	// write out unsaved changes if we panic, or are killed or hung up on.
+	defer func() {
+		if r := recover(); r != nil {
+			state.Exit()
+			panic(r)
+		}
+	}()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGHUP, syscall.SIGTERM)
+
+	autosave := time.NewTicker(*flagAutosave)
+	defer autosave.Stop()
+	checkDisk := time.NewTicker(*flagCheckDisk)
+	defer checkDisk.Stop()
+
+	var input io.Reader = tty
+	if recording != nil {
+		if recorder, err := newSessionRecorder(tty, recording, state.width, state.height); err != nil {
+			state.message = err.Error()
+		} else {
+			input = recorder
+		}
+	}
+
+	keys := make(chan key)
+	keyErrors := make(chan error, 1)
+	go func() {
+		input := bufio.NewReader(input)
+		for {
+			k, err := readKey(input)
+			if err != nil {
+				keyErrors <- err
+				return
 			}
-		} else { //normal input
-			state, err = processSingleInput(c[0], state)
+			keys <- k
+		}
+	}()
+
+	for {
+		// draw each frame in one write, so it doesn't flicker.
+		var frame bytes.Buffer
+		render(&frame, state)
+		tty.Write(frame.Bytes())
+
+		select {
+		case <-autosave.C:
+			state = state.Autosave()
+		case <-checkDisk.C:
+			state = state.CheckDisk()
+		case <-signals:
+			state.Exit()
+			return
+		case <-keyErrors:
+			state.Exit()
+			return
+		case k := <-keys:
+			state, err = processKey(k, state)
 			if err != nil {
+				state.Exit()
 				return
 			}
 		}
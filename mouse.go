@@ -0,0 +1,77 @@
+package main
+
+// wheelRows is how many rows a turn of the mouse wheel scrolls.
+const wheelRows = 3
+
+// Mouse handles a mouse event; clicking moves the cursor, dragging selects and the wheel scrolls.
+func (es editorState) Mouse(event mouseEvent) editorState {
+	switch event.action {
+	case mouseWheelUp:
+		return es.Scroll(-wheelRows)
+	case mouseWheelDown:
+		return es.Scroll(wheelRows)
+	}
+	if event.button != 0 { // only the left button
+		return es
+	}
+
+	at, ok := es.CursorAtScreen(event.row, event.col)
+	if !ok {
+		return es
+	}
+	switch event.action {
+	case mousePress:
+		es.cursor = at
+		es.mark = at
+		es.selecting = false
+		if es.vi.mode == viVisual || es.vi.mode == viVisualLine {
+			es.vi.mode = viNormal
+		}
+	case mouseDrag:
+		es.cursor = at
+		if es.vi.enabled && es.vi.mode != viInsert {
+			es.vi.mode = viVisual
+		} else {
+			es.selecting = true
+		}
+	}
+	return es
+}
+
+// CursorAtScreen returns the buffer position shown at a screen position, counted from zero.
+func (es editorState) CursorAtScreen(screenRow, screenCol int) (cursor, bool) {
+	if rows := es.TextHeight(); rows > 0 && screenRow >= rows {
+		return cursor{}, false // the status line
+	}
+	row := es.scroll + screenRow
+	if row > len(es.buffer)-1 {
+		row = len(es.buffer) - 1
+	}
+	return cursor{row: row, col: es.buffer.ColumnAtVisual(row, es.scrollLeft+screenCol, es.settings.tabWidth)}, true
+}
+
+// Scroll moves the view by a number of rows, keeping the cursor on screen.
+func (es editorState) Scroll(rows int) editorState {
+	es.scroll += rows
+	if es.scroll > len(es.buffer)-1 {
+		es.scroll = len(es.buffer) - 1
+	}
+	if es.scroll < 0 {
+		es.scroll = 0
+	}
+
+	row := es.cursor.row
+	if row < es.scroll {
+		row = es.scroll
+	}
+	if height := es.TextHeight(); height > 0 && row >= es.scroll+height {
+		row = es.scroll + height - 1
+	}
+	if row != es.cursor.row {
+		es.cursor.row = row
+		if es.cursor.col > len(es.buffer[row]) {
+			es.cursor.col = len(es.buffer[row])
+		}
+	}
+	return es
+}
@@ -0,0 +1,55 @@
+package main
+
+import "fmt"
+
+// maxCount caps the count for a command, so a mistyped one can't hang the editor repeating it.
+const maxCount = 10000
+
+// prefixArg is a numeric argument typed before a command, with C-u or M-<digit>.
+type prefixArg struct {
+	active bool
+	// value is the digits typed, or a power of four from repeating C-u.
+	value int
+	// digits is set once a digit has been typed.
+	digits bool
+}
+
+// Update returns the argument after a key, and false if the key isn't part of an argument.
+func (a prefixArg) Update(k key, prefix byte) (prefixArg, bool) {
+	if k.special != keyNone || (prefix != 0 && prefix != ANSI.esc) {
+		return a, false
+	}
+	meta := k.meta || prefix == ANSI.esc
+
+	if k.b == ANSI.nak && !meta { // C-u
+		if !a.active {
+			return prefixArg{active: true, value: 4}, true
+		}
+		if !a.digits {
+			a.value = minInt(a.value*4, maxCount)
+		}
+		return a, true
+	}
+
+	if k.b < '0' || k.b > '9' || !(meta || a.active) {
+		return a, false
+	}
+	digit := int(k.b - '0')
+	if !a.digits {
+		return prefixArg{active: true, value: digit, digits: true}, true
+	}
+	a.value = minInt(a.value*10+digit, maxCount)
+	return a, true
+}
+
+// Count returns the number of times to repeat a command.
+func (a prefixArg) Count() int {
+	if !a.active {
+		return 1
+	}
+	return a.value
+}
+
+func (a prefixArg) String() string {
+	return fmt.Sprintf("C-u %d-", a.value)
+}
@@ -0,0 +1,89 @@
+package main
+
+import "bytes"
+
+// prompt is a line of input read from the user in the status line.
+type prompt struct {
+	label string
+	input []byte
+	// done is called with the input when the user presses enter.
+	done func(editorState, string) editorState
+
+	// choices, if set, are the keys that answer the prompt straight away.
+	choices string
+	// chosen is called with the key chosen.
+	chosen func(editorState, byte) editorState
+}
+
+// Prompt starts reading a line of input, calling done with it when the user presses enter.
+func (es editorState) Prompt(label string, done func(editorState, string) editorState) editorState {
+	es.prompt = &prompt{
+		label: label,
+		done:  done,
+	}
+	return es
+}
+
+// Choose asks the user to press one of the keys in choices, calling chosen with it.
+func (es editorState) Choose(label, choices string, chosen func(editorState, byte) editorState) editorState {
+	es.prompt = &prompt{
+		label:   label,
+		choices: choices,
+		chosen:  chosen,
+	}
+	return es
+}
+
+func processPromptKey(k key, state editorState) editorState {
+	p := *state.prompt
+	if p.choices != "" {
+		if k.special != keyNone || k.meta {
+			return state
+		}
+		if k.b == ANSI.bel || k.b == ANSI.etx {
+			state.prompt = nil
+			state.message = "cancelled"
+			return state
+		}
+		if bytes.IndexByte([]byte(p.choices), k.b) >= 0 {
+			state.prompt = nil
+			return p.chosen(state, k.b)
+		}
+		return state
+	}
+	if k.special == keyPaste {
+		text := bytes.Map(func(r rune) rune {
+			if r == '\r' || r == '\n' {
+				return -1
+			}
+			return r
+		}, []byte(k.text))
+		p.input = append(append([]byte{}, p.input...), text...)
+		state.prompt = &p
+		return state
+	}
+	if k.special != keyNone || k.meta {
+		return state
+	}
+	switch k.b {
+	case ANSI.bel, ANSI.etx: // cancel
+		state.prompt = nil
+		state.message = "cancelled"
+		return state
+	case ANSI.cr, ANSI.lf:
+		state.prompt = nil
+		return p.done(state, string(p.input))
+	case ANSI.bs, ANSI.del:
+		if len(p.input) > 0 {
+			p.input = p.input[:len(p.input)-1]
+		}
+	default:
+		if k.b < ' ' {
+			return state
+		}
+		// copy so earlier states keep their input.
+		p.input = append(append([]byte{}, p.input...), k.b)
+	}
+	state.prompt = &p
+	return state
+}
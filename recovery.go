@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// recoveryPath returns the recovery file for a file: the one it has, or else where the next goes.
+func recoveryPath(path string) string {
+	candidates := recoveryPaths(path)
+	for _, candidate := range candidates {
+		if _, err := os.Lstat(candidate); err == nil {
+			return candidate
+		}
+	}
+	return candidates[0]
+}
+
+// recoveryPaths returns where the unsaved changes to a file may be autosaved: `#name#` next
+// to the file, then in the state directory for when the file's directory can't be written to.
+func recoveryPaths(path string) []string {
+	if path == "" {
+		return []string{filepath.Join(recoveryDir(), fmt.Sprintf("#untitled-%d#", os.Getpid()))}
+	}
+	dir, name := filepath.Split(path)
+	if dir == "" {
+		dir = "."
+	}
+	absolute, err := filepath.Abs(path)
+	if err != nil {
+		absolute = path
+	}
+	return []string{
+		filepath.Join(dir, "#"+name+"#"),
+		filepath.Join(recoveryDir(), "#"+strings.Replace(absolute, string(filepath.Separator), "!", -1)+"#"),
+	}
+}
+
+// untitledRecoveries returns the recovery files of untitled buffers left by sessions that have exited.
+func untitledRecoveries() []string {
+	paths, _ := filepath.Glob(filepath.Join(recoveryDir(), "#untitled-*#"))
+	var exited []string
+	for _, path := range paths {
+		name := filepath.Base(path)
+		pid, err := strconv.Atoi(name[len("#untitled-") : len(name)-1])
+		if err != nil {
+			continue
+		}
+		// an editor that's still running is autosaving to its own.
+		if pid == os.Getpid() || syscall.Kill(pid, 0) == syscall.ESRCH {
+			exited = append(exited, path)
+		}
+	}
+	return exited
+}
+
+// recoveryDir returns the state directory for recovery files.
+func recoveryDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "editor", "recovery")
+}
+
+// Autosave writes the buffer to its recovery file if it has unsaved changes that haven't been autosaved.
+func (es editorState) Autosave() editorState {
+	if !es.Modified() || es.buffer.Same(es.autosaved) || es.viewing != nil {
+		return es
+	}
+	if err := es.writeRecovery(); err != nil {
+		es.message = fmt.Sprintf("autosave failed: %v", err)
+		return es
+	}
+	es.autosaved = es.buffer
+	es.ownsRecovery = true
+	return es
+}
+
+// writeRecovery writes the buffer to its recovery file, replacing it all at once, and falling
+// back to the state directory if the file's directory can't be written to. The recovery file
+// of a large file is a patch against it, so autosaving doesn't copy the whole file.
+func (es editorState) writeRecovery() error {
+	write := es.writeText
+	if es.large != nil {
+		write = es.writePatch
+	}
+	var err error
+	for _, path := range recoveryPaths(es.path) {
+		if err = writeRecoveryFile(path, write); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+func writeRecoveryFile(path string, write func(io.Writer) error) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	temp, err := os.CreateTemp(filepath.Dir(path), ".recovery-")
+	if err != nil {
+		return err
+	}
+	if err := write(temp); err != nil {
+		temp.Close()
+		os.Remove(temp.Name())
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		os.Remove(temp.Name())
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// RemoveRecovery removes the recovery file once the changes in it are saved or discarded, if it
+// holds this session's changes; one from an earlier session the user hasn't answered about is kept.
+func (es editorState) RemoveRecovery() editorState {
+	if es.ownsRecovery {
+		for _, path := range recoveryPaths(es.path) {
+			os.Remove(path)
+		}
+		es.ownsRecovery = false
+	}
+	es.autosaved = es.buffer
+	return es
+}
+
+// Exit is called when the editor exits; unsaved changes are kept in the recovery file.
+func (es editorState) Exit() {
+	if es.viewing != nil {
+		es = es.viewing.back()
+	}
+	es.Unlock()
+	defer es.closeLarge()
+	if es.Modified() {
+		es.writeRecovery()
+		return
+	}
+	es.RemoveRecovery()
+}
+
+// CheckRecovery asks what to do if there's a recovery file newer than the file.
+func (es editorState) CheckRecovery() editorState {
+	recovery, err := os.Stat(recoveryPath(es.path))
+	if err != nil {
+		return es
+	}
+	if info, err := os.Stat(es.path); err == nil && !recovery.ModTime().After(info.ModTime()) {
+		return es
+	}
+	return es.Choose(fmt.Sprintf("%s has unsaved changes from a previous session: (r)ecover, (d)iff, (D)iscard? ", es.path), "rdD", chooseRecovery)
+}
+
+// CheckUntitledRecovery asks what to do with the unsaved changes of untitled buffers from
+// previous sessions, one at a time, when the editor starts without a file.
+func (es editorState) CheckUntitledRecovery() editorState {
+	recoveries := untitledRecoveries()
+	if len(recoveries) == 0 {
+		return es
+	}
+	path := recoveries[0]
+	label := fmt.Sprintf("an untitled buffer has unsaved changes from a previous session (%d in all): (r)ecover, (D)iscard? ", len(recoveries))
+	return es.Choose(label, "rD", func(es editorState, choice byte) editorState {
+		if choice == 'D' {
+			if err := os.Remove(path); err != nil {
+				es.message = err.Error()
+				return es
+			}
+			es = es.CheckUntitledRecovery()
+			if es.prompt == nil {
+				es.message = "discarded unsaved changes"
+			}
+			return es
+		}
+		contents, err := os.ReadFile(path)
+		if err != nil {
+			es.message = err.Error()
+			return es
+		}
+		// the recovery file becomes this session's, so it's kept until the text is saved.
+		if err := os.Rename(path, recoveryPath("")); err != nil {
+			es.message = err.Error()
+			return es
+		}
+		es.buffer = bufferFromBytes(contents)
+		es.autosaved = es.buffer
+		es.ownsRecovery = true
+		es.cursor = cursor{}
+		es.message = "recovered unsaved changes, save to keep them"
+		return es
+	})
+}
+
+func chooseRecovery(es editorState, choice byte) editorState {
+	if es.large != nil && choice != 'D' {
+		return chooseLargeRecovery(es, choice)
+	}
+	contents, err := os.ReadFile(recoveryPath(es.path))
+	if err != nil {
+		es.message = err.Error()
+		return es
+	}
+
+	switch choice {
+	case 'r':
+		// the recovered text hasn't been saved to the file yet.
+		es.buffer = bufferFromBytes(contents)
+		es.autosaved = es.buffer
+		es.ownsRecovery = true
+		es.cursor = cursor{}
+		es.message = "recovered unsaved changes, save to keep them"
+	case 'd':
+		diff := diffLines(es.path, recoveryPath(es.path), es.buffer, bufferFromBytes(contents))
+		return es.ShowText(diff, func(es editorState) editorState {
+			return es.CheckRecovery()
+		})
+	case 'D':
+		es.ownsRecovery = true
+		es = es.RemoveRecovery()
+		es.message = "discarded unsaved changes"
+	}
+	return es
+}
+
+// largePatchHeader starts the recovery file of a large file, followed by the size and
+// modification time of the file it patches.
+const largePatchHeader = "editor large file patch"
+
+var errBadPatch = errors.New("the recovery file isn't a patch the editor wrote")
+
+// writePatch writes the text with the buffer in place of the window as a patch against the
+// mapped file: runs of the file are written as their offsets, and only the edits as text.
+func (es editorState) writePatch(w io.Writer) error {
+	lt, err := es.large.commit(es.buffer)
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewWriter(w)
+	fmt.Fprintf(buffered, "%s %d %d\n", largePatchHeader, lt.mapping.stamp.size, lt.mapping.stamp.modTime.UnixNano())
+	for x := 0; x < len(lt.pieces); {
+		p := lt.pieces[x]
+		x++
+		if p.offset < 0 {
+			fmt.Fprintf(buffered, "i %d\n", len(p.data))
+			buffered.Write(p.data)
+			continue
+		}
+		// pieces that follow on in the file are written as one run.
+		length := int64(len(p.data))
+		for ; x < len(lt.pieces) && lt.pieces[x].offset == p.offset+length; x++ {
+			length += int64(len(lt.pieces[x].data))
+		}
+		fmt.Fprintf(buffered, "c %d %d\n", p.offset, length)
+	}
+	return buffered.Flush()
+}
+
+// readPatch returns the pieces of the text a patch from writePatch describes, over the mapping
+// of the file it was written against.
+func readPatch(r *bufio.Reader, m *mapping) ([]piece, error) {
+	header, err := r.ReadString('\n')
+	if err != nil || !strings.HasPrefix(header, largePatchHeader+" ") {
+		return nil, errBadPatch
+	}
+	var size, modTime int64
+	if _, err := fmt.Sscanf(header[len(largePatchHeader):], "%d %d", &size, &modTime); err != nil {
+		return nil, errBadPatch
+	}
+	if size != m.stamp.size || modTime != m.stamp.modTime.UnixNano() {
+		return nil, errors.New("the file has changed since the unsaved changes were kept")
+	}
+
+	var pieces []piece
+	err = readMapping(func() error {
+		for {
+			line, err := r.ReadString('\n')
+			if err == io.EOF && line == "" {
+				return nil
+			}
+			if err != nil {
+				return errBadPatch
+			}
+			var offset, length int64
+			if _, err := fmt.Sscanf(line, "c %d %d", &offset, &length); err == nil {
+				if offset < 0 || length < 0 || offset+length > size {
+					return errBadPatch
+				}
+				pieces = append(pieces, mappedPieces(m.data[offset:offset+length], offset)...)
+				continue
+			}
+			if _, err := fmt.Sscanf(line, "i %d", &length); err != nil || length < 0 {
+				return errBadPatch
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(r, data); err != nil {
+				return errBadPatch
+			}
+			pieces = append(pieces, piece{data: data, newlines: bytes.Count(data, []byte{byteNewLine}), offset: -1})
+		}
+	})
+	return pieces, err
+}
+
+// chooseLargeRecovery recovers a large file by applying its recovery file to the pieces of the file.
+func chooseLargeRecovery(es editorState, choice byte) editorState {
+	if choice == 'd' {
+		es.message = "too large to diff, the recovery file is kept"
+		return es
+	}
+	f, err := os.Open(recoveryPath(es.path))
+	if err != nil {
+		es.message = err.Error()
+		return es
+	}
+	defer f.Close()
+	pieces, err := readPatch(bufio.NewReader(f), es.large.mapping)
+	if err == nil {
+		lt := *es.large
+		lt.pieces = pieces
+		if lt, err = lt.windowAround(cursor{}); err == nil {
+			es.large = &lt
+		}
+	}
+	if err != nil {
+		es.message = fmt.Sprintf("can't recover: %v, the recovery file is kept", err)
+		return es
+	}
+
+	// the recovered text hasn't been saved to the file yet.
+	es.buffer = es.large.window
+	es.autosaved = es.buffer
+	es.ownsRecovery = true
+	es.cursor = cursor{}
+	es.message = "recovered unsaved changes, save to keep them"
+	return es
+}
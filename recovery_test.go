@@ -0,0 +1,180 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func TestRecovery(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "main.go")
+	assert.Nil(os.WriteFile(path, []byte("package main\n"), 0644))
+
+	state, err := loadFile(path)
+	assert.Nil(err)
+	state = state.CheckRecovery()
+	assert.Nil(state.prompt)
+	assert.False(state.Modified())
+
+	state = typeNotation(state, `"// edited" <RET>`)
+	assert.True(state.Modified())
+	state = state.Autosave()
+	recovered, err := os.ReadFile(filepath.Join(filepath.Dir(path), "#main.go#"))
+	assert.Nil(err)
+	assert.Equal("// edited\npackage main\n", string(recovered))
+
+	// make sure the recovery file is newer than the file.
+	old := time.Now().Add(-time.Minute)
+	assert.Nil(os.Chtimes(path, old, old))
+
+	state, err = loadFile(path)
+	assert.Nil(err)
+	state = state.CheckRecovery()
+	assert.NotNil(state.prompt)
+
+	diff := typeNotation(state, `d`)
+	assert.NotNil(diff.viewing)
+	assert.Equal("+// edited", string(diff.buffer[3]))
+	diff = typeNotation(diff, `q`)
+	assert.NotNil(diff.prompt)
+
+	state = typeNotation(diff, `r`)
+	assert.Equal("// edited\npackage main\n", string(state.buffer.Bytes()))
+	assert.True(state.Modified())
+
+	state = typeNotation(state, `C-x C-s`)
+	assert.False(state.Modified())
+	_, err = os.Stat(recoveryPath(path))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestDiffLines(t *testing.T) {
+	assert := assert.New(t)
+
+	a := bufferFromBytes([]byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nj\nk"))
+	b := bufferFromBytes([]byte("a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\nl"))
+	assert.Equal("--- a\n+++ b\n@@ -1,5 +1,5 @@\n a\n-b\n+B\n c\n d\n e\n@@ -9,3 +9,4 @@\n i\n j\n k\n+l\n", string(diffLines("a", "b", a, b)))
+	assert.Nil(diffLines("a", "a", a, a))
+}
+
+func TestDiffOpsLongFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	a := make([][]byte, 60000)
+	for y := range a {
+		a[y] = []byte(fmt.Sprintf("row %d", y))
+	}
+	b := append([][]byte{[]byte("first")}, a[1:len(a)-1]...)
+	b = append(b, []byte("last"))
+	assert.Equal("--- a\n+++ b\n@@ -1,4 +1,4 @@\n-row 0\n+first\n row 1\n row 2\n row 3\n@@ -59997,4 +59997,4 @@\n row 59996\n row 59997\n row 59998\n-row 59999\n+last\n", string(diffLines("a", "b", a, b)))
+
+	// rows that differ by more than the diff looks for are removed then added.
+	c := make([][]byte, len(a))
+	for y := range c {
+		c[y] = []byte(fmt.Sprintf("other %d", y))
+	}
+	ops := diffOps(a, c)
+	assert.Len(ops, 2*len(a))
+	assert.Equal(diffOp{kind: '-', a: 0, b: 0}, ops[0])
+	assert.Equal(diffOp{kind: '+', a: len(a), b: 0}, ops[len(a)])
+}
+
+func TestRecoveryKeptUntilAnswered(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	assert.Nil(os.WriteFile(path, []byte("one\n"), 0644))
+	old := time.Now().Add(-time.Minute)
+	assert.Nil(os.Chtimes(path, old, old))
+	assert.Nil(os.WriteFile(recoveryPath(path), []byte("one edited\n"), 0644))
+
+	// cancelling the question then quitting keeps the changes for next time.
+	state, err := loadFile(path)
+	assert.Nil(err)
+	state = state.CheckRecovery()
+	assert.NotNil(state.prompt)
+	state = typeNotation(state, `C-g`)
+	state.Exit()
+	_, err = os.Stat(recoveryPath(path))
+	assert.Nil(err)
+
+	state, err = loadFile(path)
+	assert.Nil(err)
+	state = state.CheckRecovery()
+	state = typeNotation(state, `D`)
+	assert.Equal("discarded unsaved changes", state.message)
+	_, err = os.Stat(recoveryPath(path))
+	assert.True(os.IsNotExist(err))
+}
+
+func TestRecoveryFallsBackToStateDir(t *testing.T) {
+	assert := assert.New(t)
+	if os.Geteuid() == 0 {
+		t.Skip("root can write to any directory")
+	}
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	dir := t.TempDir()
+	path := filepath.Join(dir, "notes.txt")
+	assert.Nil(os.WriteFile(path, []byte("one\n"), 0644))
+	assert.Nil(os.Chmod(dir, 0555))
+	defer os.Chmod(dir, 0755)
+
+	state, err := loadFile(path)
+	assert.Nil(err)
+	state.locked = true // the lock can't be written here either
+	state = typeNotation(state, `"x"`).Autosave()
+	assert.Empty(state.message)
+	assert.True(strings.HasPrefix(recoveryPath(path), recoveryDir()))
+}
+
+func TestUntitledRecovery(t *testing.T) {
+	assert := assert.New(t)
+
+	t.Setenv("XDG_CACHE_HOME", t.TempDir())
+	state := newEditorState()
+	state = typeNotation(state, `"lost"`).Autosave()
+	assert.Equal([]string{recoveryPath("")}, untitledRecoveries())
+
+	// the recovery file of a session that has exited is offered when the editor starts without a file.
+	exited := exec.Command("true")
+	assert.Nil(exited.Run())
+	left := filepath.Join(recoveryDir(), fmt.Sprintf("#untitled-%d#", exited.Process.Pid))
+	assert.Nil(os.Rename(recoveryPath(""), left))
+	// one from an editor that's still running isn't.
+	running := filepath.Join(recoveryDir(), fmt.Sprintf("#untitled-%d#", os.Getppid()))
+	assert.Nil(os.WriteFile(running, []byte("running"), 0600))
+	assert.Equal([]string{left}, untitledRecoveries())
+
+	state = newEditorState().CheckUntitledRecovery()
+	assert.NotNil(state.prompt)
+	state = typeNotation(state, `r`)
+	assert.Equal("lost", string(state.buffer.Bytes()))
+	assert.True(state.Modified())
+	_, err := os.Stat(left)
+	assert.True(os.IsNotExist(err))
+	_, err = os.Stat(recoveryPath(""))
+	assert.Nil(err)
+
+	// saving it removes the untitled recovery file.
+	path := filepath.Join(t.TempDir(), "found.txt")
+	state = typeNotation(state, `C-x C-s "`+path+`" <RET>`)
+	assert.Equal("wrote "+path, state.message)
+	assert.Empty(untitledRecoveries())
+	_, err = os.Stat(running)
+	assert.Nil(err)
+
+	assert.Nil(os.WriteFile(left, []byte("unwanted"), 0600))
+	state = typeNotation(newEditorState().CheckUntitledRecovery(), `D`)
+	assert.Nil(state.prompt)
+	assert.Equal("discarded unsaved changes", state.message)
+	assert.Empty(untitledRecoveries())
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files in testdata")
+
+// renderScreen draws a state on a virtual terminal of its size.
+func renderScreen(state editorState) *virtualTerminal {
+	vt := newVirtualTerminal(state.width, state.height)
+	render(vt, state)
+	return vt
+}
+
+// assertGolden compares the output to testdata/name, or rewrites it with -update.
+func assertGolden(t *testing.T, name, output string) {
+	path := filepath.Join("testdata", name)
+	if *updateGolden {
+		if err := os.WriteFile(path, []byte(output), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	golden, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.New(t).Equal(string(golden), output)
+}
+
+func TestRenderGolden(t *testing.T) {
+	cases := []struct {
+		name     string
+		contents string
+		width    int
+		height   int
+		keys     string
+		// selectFrom starts a selection there before the keys are typed.
+		selectFrom *cursor
+	}{
+		{name: "tabs", contents: "func main() {\n\treturn\n}\n", width: 20, height: 5, keys: `<Down> C-f`},
+		{name: "clipped", contents: "a row that is longer than the screen\n\tand a tab\n", width: 12, height: 4, keys: `<Down> C-e`},
+		{name: "clipped-tab", contents: "\t\tlong\n", width: 6, height: 3, keys: `C-e`},
+		{name: "scrolled", contents: "1\n2\n3\n4\n5\n6\n7\n8\n", width: 10, height: 4, keys: `<Down> <Down> <Down> <Down> <Down>`},
+		{name: "selection", contents: "one two\nthree\n", width: 10, height: 4, keys: `M-f <Down>`, selectFrom: &cursor{}},
+		{name: "brackets", contents: "if (a[0]) {\n}\n", width: 14, height: 4, keys: `M-f C-f`},
+		{name: "unbalanced", contents: "f(a]\n", width: 20, height: 3, keys: `C-e`},
+		{name: "prompt", contents: "one\n", width: 20, height: 3, keys: `M-g "12"`},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			state := stateFromString(c.contents).Resize(c.width, c.height)
+			if c.selectFrom != nil {
+				state.mark = *c.selectFrom
+				state.selecting = true
+			}
+			state = typeNotation(state, c.keys)
+			assertGolden(t, filepath.Join("render", c.name+".golden"), renderScreen(state).String())
+		})
+	}
+}
+
+func TestVirtualTerminal(t *testing.T) {
+	assert := assert.New(t)
+
+	vt := newVirtualTerminal(5, 2)
+	vt.Write([]byte("abc"))
+	// sequences can be split across writes.
+	vt.Write([]byte{ANSI.esc, '['})
+	vt.Write([]byte("7mdefg\x1b[0m"))
+	assert.Equal("abcde", vt.Text(0))
+	assert.Equal("fg", vt.Text(1))
+	assert.Equal(styleReverse, vt.cells[1][0].style)
+
+	vt.Write(ANSI.MoveCursor(1, 2))
+	vt.Write([]byte("Z"))
+	assert.Equal("aZcde", vt.Text(0))
+	assert.Equal(2, vt.col)
+
+	vt.Write(ANSI.ClearScreen())
+	assert.Equal("", vt.Text(0))
+}
+
+func TestHorizontalScroll(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("a row that is longer than the screen\nshort\n").Resize(12, 4)
+	state = typeNotation(state, `C-e`)
+	assert.Equal(25, state.scrollLeft)
+	assert.Equal(" the screen", renderScreen(state).Text(0))
+
+	// clicks are on the scrolled text.
+	at, ok := state.CursorAtScreen(0, 2)
+	assert.True(ok)
+	assert.Equal(cursor{row: 0, col: 27}, at)
+
+	state = typeNotation(state, `C-a`)
+	assert.Equal(0, state.scrollLeft)
+	assert.Equal("a row that i", renderScreen(state).Text(0))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// Save writes the buffer to its file, asking for a path if it doesn't have one.
+func (es editorState) Save() editorState {
+	if es.path == "" {
+		return es.PromptSaveAs()
+	}
+	if _, changed := es.diskChange(); changed {
+		return es.chooseDiskChange()
+	}
+	return es.save()
+}
+
+// save writes the buffer to its file, cleaning up whitespace and formatting it first if set to.
+func (es editorState) save() editorState {
+	// hex and large buffers are written back as they are: the clean ups would change bytes
+	// in binary files, and walk or copy every row of large ones.
+	if es.hex.enabled || es.large != nil {
+		return es.write()
+	}
+	if es.settings.trimTrailingWhitespace {
+		es = es.TrimTrailingWhitespace(es.settings.trimModifiedOnly)
+	}
+	if es.settings.finalNewline {
+		es = es.EnsureFinalNewline()
+	}
+	if es.settings.formatOnSave && es.settings.language.name == languageGo.name {
+		formatted := es.Format()
+		if formatted.message != "" { // save anyway, but keep the syntax error visible
+			es = es.write()
+			es.message = formatted.message
+			return es
+		}
+		es = formatted
+	}
+	return es.write()
+}
+
+// PromptSaveAs asks for a path and saves the buffer to it.
+func (es editorState) PromptSaveAs() editorState {
+	return es.Prompt("Save as: ", func(es editorState, path string) editorState {
+		if path == "" {
+			es.message = "not saved"
+			return es
+		}
+		if path != es.path {
+			// unsaved changes are autosaved under the new name from now on.
+			if es.ownsRecovery {
+				for _, recovery := range recoveryPaths(es.path) {
+					os.Remove(recovery)
+				}
+				es.ownsRecovery = false
+			}
+			es.autosaved = nil
+			es = es.Unlock()
+			es.path = path
+			es.disk = fileStamp{}
+		}
+		return es.Save()
+	})
+}
+
+// encoded returns the buffer as it's written to its file, with its line ending and in its encoding.
+func (es editorState) encoded() ([]byte, error) {
+	return encodeText(es.encodeLineEndings(), es.encoding)
+}
+
+// writeEncoded writes the text as it's written to its file: a large file is streamed as it is,
+// and any other is encoded.
+func (es editorState) writeEncoded(w io.Writer) error {
+	if es.large != nil {
+		return es.large.writeTo(w, es.buffer)
+	}
+	contents, err := es.encoded()
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(contents)
+	return err
+}
+
+func (es editorState) write() editorState {
+	mode := os.FileMode(0644)
+	if info, err := os.Stat(es.path); err == nil {
+		mode = info.Mode()
+	}
+	var err error
+	if es.large != nil {
+		// large files are streamed, and replaced rather than truncated, which would pull
+		// the file out from under the mapping.
+		err = writeReplacing(es.path, mode, es.writeEncoded)
+	} else {
+		var contents []byte
+		if contents, err = es.encoded(); err == nil {
+			err = os.WriteFile(es.path, contents, mode)
+		}
+	}
+	if err != nil {
+		es.message = err.Error()
+		return es
+	}
+	es.saved = es.buffer
+	es.savedLineEnding = es.lineEnding
+	es.disk, _ = stampFile(es.path)
+	es.diskChanged = false
+	es = es.RemoveRecovery().Unlock()
+	es.message = fmt.Sprintf("wrote %s", es.path)
+	if es.large != nil {
+		remapped, err := es.remapLarge()
+		if err != nil {
+			es.message = fmt.Sprintf("wrote %s, but can't map it again, so unsaved changes can't be recovered: %v", es.path, err)
+			return es
+		}
+		es = remapped
+	}
+	return es
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// session is a recording of the raw input to the editor, so it can be replayed.
+//
+// It's saved as text, a `size width height` line then an `input elapsed "bytes"` line for
+// each read from the terminal, with the bytes quoted like a go string.
+type session struct {
+	width, height int
+	inputs        []sessionInput
+}
+
+// sessionInput is the bytes of one read from the terminal.
+type sessionInput struct {
+	at   time.Duration
+	data []byte
+}
+
+// sessionRecorder writes everything read from a terminal to a session file as it's read.
+type sessionRecorder struct {
+	tty     io.Reader
+	session io.Writer
+	start   time.Time
+}
+
+// newSessionRecorder starts a session file for a terminal of a size.
+func newSessionRecorder(tty io.Reader, session io.Writer, width, height int) (*sessionRecorder, error) {
+	if _, err := fmt.Fprintf(session, "size %d %d\n", width, height); err != nil {
+		return nil, err
+	}
+	return &sessionRecorder{tty: tty, session: session, start: time.Now()}, nil
+}
+
+// Read reads from the terminal, recording what was read.
+func (sr *sessionRecorder) Read(p []byte) (int, error) {
+	n, err := sr.tty.Read(p)
+	if n > 0 {
+		elapsed := time.Since(sr.start).Round(time.Millisecond)
+		fmt.Fprintf(sr.session, "input %s %s\n", elapsed, strconv.Quote(string(p[0:n])))
+	}
+	return n, err
+}
+
+// readSession parses a session file, skipping blank lines and lines starting with `#`.
+func readSession(r io.Reader) (session, error) {
+	var s session
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.SplitN(text, " ", 3)
+		switch {
+		case fields[0] == "size" && len(fields) == 3:
+			width, widthErr := strconv.Atoi(fields[1])
+			height, heightErr := strconv.Atoi(fields[2])
+			if widthErr != nil || heightErr != nil {
+				return session{}, fmt.Errorf("line %d: bad size: %s", line, text)
+			}
+			s.width, s.height = width, height
+		case fields[0] == "input" && len(fields) == 3:
+			at, err := time.ParseDuration(fields[1])
+			if err != nil {
+				return session{}, fmt.Errorf("line %d: bad time: %v", line, err)
+			}
+			data, err := strconv.Unquote(fields[2])
+			if err != nil {
+				return session{}, fmt.Errorf("line %d: bad input: %s", line, fields[2])
+			}
+			s.inputs = append(s.inputs, sessionInput{at: at, data: []byte(data)})
+		default:
+			return session{}, fmt.Errorf("line %d: unknown line: %s", line, text)
+		}
+	}
+	return s, scanner.Err()
+}
+
+// inputReader returns each recorded input in its own read, like the terminal did,
+// so a lone escape is still told apart from the start of a sequence.
+type inputReader struct {
+	inputs []sessionInput
+	unread []byte
+}
+
+func (ir *inputReader) Read(p []byte) (int, error) {
+	if len(ir.unread) == 0 {
+		if len(ir.inputs) == 0 {
+			return 0, io.EOF
+		}
+		ir.unread = ir.inputs[0].data
+		ir.inputs = ir.inputs[1:]
+	}
+	n := copy(p, ir.unread)
+	ir.unread = ir.unread[n:]
+	return n, nil
+}
+
+// Replay feeds the recorded input through the key decoder and handlers, at the recorded screen size.
+func (s session) Replay(state editorState) (editorState, error) {
+	if s.width > 0 && s.height > 0 {
+		state = state.Resize(s.width, s.height)
+	}
+	input := bufio.NewReader(&inputReader{inputs: s.inputs})
+	for {
+		k, err := readKey(input)
+		if err == io.EOF {
+			return state, nil
+		}
+		if err != nil {
+			return state, err
+		}
+		state, err = processKey(k, state)
+		if err == errQuit {
+			return state, nil
+		}
+		if err != nil {
+			return state, err
+		}
+	}
+}
+
+// runReplay replays a session file over a copy of the buffer's file, so anything saved
+// in the session doesn't touch it, then writes the final screen and buffer.
+func runReplay(sessionPath string, state editorState, stdout io.Writer) error {
+	f, err := os.Open(sessionPath)
+	if err != nil {
+		return err
+	}
+	s, err := readSession(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("%s: %v", sessionPath, err)
+	}
+
+	if state.path != "" {
+		dir, err := os.MkdirTemp("", "editor-replay")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+		state.path = filepath.Join(dir, filepath.Base(state.path))
+		if err := writeReplacing(state.path, 0644, state.writeEncoded); err != nil {
+			return err
+		}
+		state.disk, _ = stampFile(state.path)
+	}
+
+	state, err = s.Replay(state)
+	if err != nil {
+		return err
+	}
+
+	var frame bytes.Buffer
+	render(&frame, state)
+	screen := newVirtualTerminal(state.width, state.height)
+	screen.Write(frame.Bytes())
+
+	fmt.Fprintf(stdout, "%s--- buffer\n", screen)
+	return state.writeEncoded(stdout)
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"path/filepath"
+	"testing"
+	"time"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func TestSessionRecorder(t *testing.T) {
+	assert := assert.New(t)
+
+	tty := &inputReader{inputs: []sessionInput{{data: []byte("ab")}, {data: []byte("\x1b[A")}}}
+	var recording bytes.Buffer
+	recorder, err := newSessionRecorder(tty, &recording, 80, 24)
+	assert.Nil(err)
+
+	input := make([]byte, 16)
+	for {
+		if _, err := recorder.Read(input); err != nil {
+			break
+		}
+	}
+
+	s, err := readSession(&recording)
+	assert.Nil(err)
+	assert.Equal(80, s.width)
+	assert.Equal(24, s.height)
+	assert.Len(s.inputs, 2)
+	assert.Equal("ab", string(s.inputs[0].data))
+	assert.Equal("\x1b[A", string(s.inputs[1].data))
+	assert.True(s.inputs[1].at < time.Second)
+}
+
+func TestReplaySessions(t *testing.T) {
+	cases := []struct {
+		name     string
+		contents string
+		vi       bool
+	}{
+		{name: "emacs", contents: "one\ntwo\n"},
+		{name: "vi-escape", contents: "\n", vi: true},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			state := stateFromString(c.contents)
+			state.vi.enabled = c.vi
+			var output bytes.Buffer
+			if err := runReplay(filepath.Join("testdata", "sessions", c.name+".session"), state, &output); err != nil {
+				t.Fatal(err)
+			}
+			assertGolden(t, filepath.Join("sessions", c.name+".golden"), output.String())
+		})
+	}
+}
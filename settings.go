@@ -0,0 +1,62 @@
+package main
+
+// defaultWordChars are the non-alphanumeric bytes that are part of a go identifier.
+const defaultWordChars = "_"
+
+// defaultTabWidth is how many columns a tab is drawn in.
+const defaultTabWidth = 4
+
+func defaultSettings() settings {
+	return settings{
+		wordChars: defaultWordChars,
+		indent:    languageText.indent,
+		tabWidth:  defaultTabWidth,
+		language:  languageText,
+	}
+}
+
+// settings are the user configurable options for an editor.
+type settings struct {
+	// wordChars are the bytes, beyond letters and digits, that are treated as part of a word.
+	wordChars string
+	// indent is inserted for each level of indentation.
+	indent string
+	// tabWidth is how many columns a tab is drawn in.
+	tabWidth int
+	// language holds the indentation rules for the file type.
+	language language
+	// formatOnSave runs go files through gofmt before they are saved.
+	formatOnSave bool
+	// trimTrailingWhitespace removes whitespace from the ends of rows when saving,
+	// only from rows changed since the last save if trimModifiedOnly is set.
+	trimTrailingWhitespace bool
+	trimModifiedOnly       bool
+	// finalNewline makes the file end with exactly one newline when saving.
+	finalNewline bool
+	// showTrailingWhitespace highlights whitespace at the ends of rows.
+	showTrailingWhitespace bool
+	// maxLineLength highlights the text past this many columns, if it's set.
+	maxLineLength int
+}
+
+// settingsForPath returns the default settings for editing a file.
+func settingsForPath(path string) settings {
+	s := defaultSettings()
+	s.language = languageForPath(path)
+	s.indent = s.language.indent
+	return s
+}
+
+// IsWordChar returns if a byte is part of a word for word motions.
+// Bytes outside ascii are always word characters so utf-8 identifiers are not split.
+func (s settings) IsWordChar(b byte) bool {
+	if b >= 'a' && b <= 'z' || b >= 'A' && b <= 'Z' || b >= '0' && b <= '9' || b >= 0x80 {
+		return true
+	}
+	for x := 0; x < len(s.wordChars); x++ {
+		if s.wordChars[x] == b {
+			return true
+		}
+	}
+	return false
+}
@@ -64,3 +64,20 @@ func MakeRaw(fd uintptr) (*Termios, error) {
 	}
 	return old, nil
 }
+
+// WinSize is the size of a terminal.
+type WinSize struct {
+	Rows   uint16
+	Cols   uint16
+	Xpixel uint16
+	Ypixel uint16
+}
+
+// GetWinSize retrieves the size of the terminal connected to the given file descriptor.
+func GetWinSize(fd uintptr) (*WinSize, error) {
+	var size = &WinSize{}
+	if _, _, err := syscall.Syscall(syscall.SYS_IOCTL, fd, syscall.TIOCGWINSZ, uintptr(unsafe.Pointer(size))); err != 0 {
+		return nil, err
+	}
+	return size, nil
+}
@@ -0,0 +1,713 @@
+package main
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+)
+
+type viMode int
+
+// the vi modes.
+const (
+	viNormal viMode = iota
+	viInsert
+	viVisual
+	viVisualLine
+)
+
+func (m viMode) String() string {
+	switch m {
+	case viInsert:
+		return "-- INSERT --"
+	case viVisual:
+		return "-- VISUAL --"
+	case viVisualLine:
+		return "-- VISUAL LINE --"
+	default:
+		return ""
+	}
+}
+
+// viState is the state of the vi keymap between keys.
+type viState struct {
+	enabled bool
+	mode    viMode
+
+	// count is the count typed so far, zero if none.
+	count int
+	// operator is a pending d, c or y, waiting for a motion.
+	operator byte
+	// operatorCount is the count typed before the operator.
+	operatorCount int
+	// pending is the first key of a two key command, like g or the i of a text object.
+	pending byte
+}
+
+// reset clears any partially typed command.
+func (vi viState) reset() viState {
+	vi.count = 0
+	vi.operator = 0
+	vi.operatorCount = 0
+	vi.pending = 0
+	return vi
+}
+
+// viTarget is where a motion moves the cursor.
+type viTarget struct {
+	at cursor
+	// linewise motions operate on whole rows.
+	linewise bool
+	// inclusive motions operate on the byte at the target too.
+	inclusive bool
+}
+
+// viRange is the text an operator acts on.
+type viRange struct {
+	// from and to are ordered, to is exclusive unless the range is linewise.
+	from, to cursor
+	linewise bool
+}
+
+// clipboard holds yanked text.
+type clipboard struct {
+	text     []byte
+	linewise bool
+}
+
+// processViKey handles keys for the vi keymap.
+func processViKey(k key, state editorState) (editorState, error) {
+	if state.prefix != 0 {
+		return processEmacsKey(k, state)
+	}
+	if state.vi.mode == viInsert {
+		if k.special == keyNone && !k.meta && k.b == ANSI.esc {
+			state.vi.mode = viNormal
+			return state.MoveLeft(), nil
+		}
+		return processEmacsKey(k, state)
+	}
+
+	b := k.b
+	switch k.special {
+	case keyNone:
+	case keyLeft:
+		b = 'h'
+	case keyDown:
+		b = 'j'
+	case keyUp:
+		b = 'k'
+	case keyRight:
+		b = 'l'
+	case keyHome:
+		b = '0'
+	case keyEnd:
+		b = '$'
+	case keyDelete:
+		b = 'x'
+	default:
+		return state, nil
+	}
+
+	if k.special == keyNone && (k.meta || b < ' ' && b != ANSI.esc) {
+		// control and meta keys keep their usual bindings, like C-x C-s.
+		state.vi = state.vi.reset()
+		return processEmacsKey(k, state)
+	}
+	return processViCommand(b, state)
+}
+
+func processViCommand(b byte, state editorState) (editorState, error) {
+	vi := state.vi
+	state.vi = vi.reset()
+
+	if b == ANSI.esc {
+		return state.viExitVisual(), nil
+	}
+
+	switch vi.pending {
+	case 'g':
+		if b == 'g' {
+			return state.viMotion('g', vi)
+		}
+		return state, nil
+	case 'i', 'a':
+		r, ok := state.viTextObject(vi.pending, b)
+		if !ok {
+			return state, nil
+		}
+		if state.vi.mode == viVisual || state.vi.mode == viVisualLine {
+			state.mark = r.from
+			state.cursor, _ = state.buffer.Prev(r.to)
+			return state, nil
+		}
+		return state.viApply(vi.operator, r), nil
+	}
+
+	if b >= '1' && b <= '9' || b == '0' && vi.count > 0 {
+		vi.count = minInt(vi.count*10+int(b-'0'), maxCount)
+		state.vi = vi
+		return state, nil
+	}
+
+	visual := state.vi.mode == viVisual || state.vi.mode == viVisualLine
+	switch b {
+	case 'g':
+		vi.pending = 'g'
+		state.vi = vi
+		return state, nil
+	case 'i', 'a':
+		if vi.operator != 0 || visual {
+			vi.pending = b
+			state.vi = vi
+			return state, nil
+		}
+	case 'd', 'c', 'y':
+		if visual {
+			return state.viApplySelection(b), nil
+		}
+		if vi.operator == b { // dd, cc and yy act on whole rows
+			count := minInt(viCount(vi.operatorCount)*viCount(vi.count), maxCount)
+			last := state.cursor.row + count - 1
+			if last > len(state.buffer)-1 {
+				last = len(state.buffer) - 1
+			}
+			return state.viApply(b, viRange{from: cursor{row: state.cursor.row}, to: cursor{row: last}, linewise: true}), nil
+		}
+		vi.operator = b
+		vi.operatorCount = vi.count
+		vi.count = 0
+		state.vi = vi
+		return state, nil
+	}
+
+	if _, ok := viMotions[b]; ok {
+		return state.viMotion(b, vi)
+	}
+	if vi.operator != 0 { // an operator needs a motion
+		return state, nil
+	}
+	if visual {
+		return state.viVisualCommand(b), nil
+	}
+	return state.viNormalCommand(b, viCount(vi.count))
+}
+
+// viMotions are the keys that move the cursor.
+var viMotions = map[byte]bool{
+	'h': true, 'j': true, 'k': true, 'l': true, ' ': true,
+	'w': true, 'b': true, 'e': true,
+	'0': true, '^': true, '$': true,
+	'G': true, '{': true, '}': true, '%': true,
+}
+
+func viCount(count int) int {
+	if count == 0 {
+		return 1
+	}
+	return count
+}
+
+// viMotion moves the cursor, or applies a pending operator up to where the cursor would move.
+func (es editorState) viMotion(b byte, vi viState) (editorState, error) {
+	count := minInt(viCount(vi.operatorCount)*viCount(vi.count), maxCount)
+	if vi.operator == 'c' && b == 'w' && viClass(es.settings, es.buffer.ByteAt(es.cursor)) != 0 {
+		b = 'e' // cw changes to the end of the word, like ce
+	}
+
+	target := es.viTarget(b, count, vi.count > 0 || vi.operatorCount > 0)
+	if vi.operator == 0 {
+		es.cursor = target.at
+		return es, nil
+	}
+
+	from, to := es.cursor, target.at
+	if to.row < from.row || (to.row == from.row && to.col < from.col) {
+		from, to = to, from
+	}
+	if target.linewise {
+		return es.viApply(vi.operator, viRange{from: from, to: to, linewise: true}), nil
+	}
+	if target.inclusive {
+		to, _ = es.buffer.Next(to)
+	}
+	if b == 'w' && to.row > from.row && to.col == 0 {
+		// a word motion off the end of a row stops at the end of the row.
+		to = cursor{row: to.row - 1, col: len(es.buffer[to.row-1])}
+		if to.row < from.row || to.row == from.row && to.col < from.col {
+			to = from
+		}
+	}
+	return es.viApply(vi.operator, viRange{from: from, to: to}), nil
+}
+
+// viTarget returns where a motion moves the cursor.
+func (es editorState) viTarget(b byte, count int, hasCount bool) viTarget {
+	at := es.cursor
+	switch b {
+	case 'h':
+		for x := 0; x < count; x++ {
+			es = es.MoveLeft()
+		}
+		return viTarget{at: es.cursor}
+	case 'l', ' ':
+		for x := 0; x < count; x++ {
+			es = es.MoveRight()
+		}
+		return viTarget{at: es.cursor}
+	case 'j':
+		for x := 0; x < count; x++ {
+			es = es.MoveDown()
+		}
+		return viTarget{at: es.cursor, linewise: true}
+	case 'k':
+		for x := 0; x < count; x++ {
+			es = es.MoveUp()
+		}
+		return viTarget{at: es.cursor, linewise: true}
+	case 'w':
+		for x := 0; x < count; x++ {
+			at = es.viWordForward(at)
+		}
+		return viTarget{at: at}
+	case 'e':
+		for x := 0; x < count; x++ {
+			at = es.viWordEnd(at)
+		}
+		return viTarget{at: at, inclusive: true}
+	case 'b':
+		for x := 0; x < count; x++ {
+			at = es.viWordBackward(at)
+		}
+		return viTarget{at: at}
+	case '0':
+		return viTarget{at: cursor{row: at.row}}
+	case '^':
+		return viTarget{at: cursor{row: at.row, col: len(leadingWhitespace(es.buffer[at.row]))}}
+	case '$':
+		row := at.row + count - 1
+		if row > len(es.buffer)-1 {
+			row = len(es.buffer) - 1
+		}
+		return viTarget{at: cursor{row: row, col: len(es.buffer[row])}}
+	case 'g', 'G':
+		if !hasCount && b == 'G' {
+			es = es.MoveToEndOfBuffer()
+		} else if !hasCount {
+			es = es.MoveToBeginningOfBuffer()
+		} else {
+			es = es.GotoLine(count)
+		}
+		row := es.cursor.row
+		return viTarget{at: cursor{row: row, col: len(leadingWhitespace(es.buffer[row]))}, linewise: true}
+	case '}':
+		for x := 0; x < count; x++ {
+			es = es.MoveParagraphForward()
+		}
+		return viTarget{at: es.cursor}
+	case '{':
+		for x := 0; x < count; x++ {
+			es = es.MoveParagraphBackward()
+		}
+		return viTarget{at: es.cursor}
+	case '%':
+		_, match, matched, ok := es.BracketAtCursor()
+		if !ok || !matched {
+			return viTarget{at: at}
+		}
+		return viTarget{at: match, inclusive: true}
+	}
+	return viTarget{at: at}
+}
+
+// viClass returns the class of a byte for vi word motions;
+// blanks are 0, word bytes are 1 and punctuation is 2.
+func viClass(s settings, c byte) int {
+	switch {
+	case c == ' ' || c == byteTab || c == byteNewLine:
+		return 0
+	case s.IsWordChar(c):
+		return 1
+	default:
+		return 2
+	}
+}
+
+// viWordForward returns the start of the next word.
+func (es editorState) viWordForward(at cursor) cursor {
+	ok := true
+	if class := viClass(es.settings, es.buffer.ByteAt(at)); class != 0 {
+		for ok && viClass(es.settings, es.buffer.ByteAt(at)) == class {
+			at, ok = es.buffer.Next(at)
+		}
+	}
+	for ok && viClass(es.settings, es.buffer.ByteAt(at)) == 0 {
+		at, ok = es.buffer.Next(at)
+	}
+	return at
+}
+
+// viWordEnd returns the last byte of the current or next word.
+func (es editorState) viWordEnd(at cursor) cursor {
+	at, ok := es.buffer.Next(at)
+	for ok && viClass(es.settings, es.buffer.ByteAt(at)) == 0 {
+		at, ok = es.buffer.Next(at)
+	}
+	class := viClass(es.settings, es.buffer.ByteAt(at))
+	for {
+		next, ok := es.buffer.Next(at)
+		if !ok || viClass(es.settings, es.buffer.ByteAt(next)) != class {
+			return at
+		}
+		at = next
+	}
+}
+
+// viWordBackward returns the start of the current or previous word.
+func (es editorState) viWordBackward(at cursor) cursor {
+	at, ok := es.buffer.Prev(at)
+	for ok && viClass(es.settings, es.buffer.ByteAt(at)) == 0 {
+		at, ok = es.buffer.Prev(at)
+	}
+	class := viClass(es.settings, es.buffer.ByteAt(at))
+	for {
+		prev, ok := es.buffer.Prev(at)
+		if !ok || viClass(es.settings, es.buffer.ByteAt(prev)) != class {
+			return at
+		}
+		at = prev
+	}
+}
+
+// viTextObject returns the range of a text object, like `iw` or `a(`, around the cursor.
+func (es editorState) viTextObject(kind, b byte) (viRange, bool) {
+	switch b {
+	case 'w':
+		row := es.buffer[es.cursor.row]
+		if len(row) == 0 {
+			return viRange{}, false
+		}
+		col := es.cursor.col
+		if col >= len(row) {
+			col = len(row) - 1
+		}
+		class := viClass(es.settings, row[col])
+		from, to := col, col
+		for from > 0 && viClass(es.settings, row[from-1]) == class {
+			from--
+		}
+		for to < len(row) && viClass(es.settings, row[to]) == class {
+			to++
+		}
+		if kind == 'a' {
+			if to < len(row) && isBlank(row[to]) {
+				for to < len(row) && isBlank(row[to]) {
+					to++
+				}
+			} else {
+				for from > 0 && isBlank(row[from-1]) {
+					from--
+				}
+			}
+		}
+		return viRange{from: cursor{row: es.cursor.row, col: from}, to: cursor{row: es.cursor.row, col: to}}, true
+	case '"', '\'', '`':
+		row := es.buffer[es.cursor.row]
+		open := bytes.LastIndexByte(row[0:minInt(es.cursor.col+1, len(row))], b)
+		if open < 0 {
+			return viRange{}, false
+		}
+		close := bytes.IndexByte(row[open+1:], b)
+		if close < 0 {
+			return viRange{}, false
+		}
+		close += open + 1
+		if kind == 'a' {
+			return viRange{from: cursor{row: es.cursor.row, col: open}, to: cursor{row: es.cursor.row, col: close + 1}}, true
+		}
+		return viRange{from: cursor{row: es.cursor.row, col: open + 1}, to: cursor{row: es.cursor.row, col: close}}, true
+	}
+
+	var open byte
+	switch b {
+	case '(', ')', 'b':
+		open = '('
+	case '[', ']':
+		open = '['
+	case '{', '}', 'B':
+		open = '{'
+	default:
+		return viRange{}, false
+	}
+
+	// the innermost pair of brackets around the cursor.
+	brackets := es.buffer.Brackets(es.settings.language)
+	for x := len(brackets) - 1; x >= 0; x-- {
+		bracket := brackets[x]
+		if bracket.c != open || bracket.match < 0 || cursorAfter(bracket.at, es.cursor) {
+			continue
+		}
+		close := brackets[bracket.match].at
+		if cursorAfter(es.cursor, close) {
+			continue
+		}
+		if kind == 'a' {
+			return viRange{from: bracket.at, to: cursor{row: close.row, col: close.col + 1}}, true
+		}
+		return viRange{from: cursor{row: bracket.at.row, col: bracket.at.col + 1}, to: close}, true
+	}
+	return viRange{}, false
+}
+
+// cursorAfter returns if a position is after another.
+func cursorAfter(a, b cursor) bool {
+	return a.row > b.row || (a.row == b.row && a.col > b.col)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// viApply applies an operator to a range.
+func (es editorState) viApply(operator byte, r viRange) editorState {
+	if r.linewise {
+		return es.viApplyLines(operator, r.from.row, r.to.row)
+	}
+
+	es.clipboard = clipboard{text: es.buffer.Text(r.from, r.to)}
+	switch operator {
+	case 'd', 'c':
+		es.buffer = es.buffer.RemoveRange(r.from, r.to)
+		if operator == 'c' {
+			es.vi.mode = viInsert
+		}
+	}
+	es.cursor = r.from
+	es.mark = cursor{}
+	return es.viClampCursor()
+}
+
+// viApplyLines applies an operator to whole rows.
+func (es editorState) viApplyLines(operator byte, first, last int) editorState {
+	text := es.buffer.Text(cursor{row: first}, cursor{row: last, col: len(es.buffer[last])})
+	es.clipboard = clipboard{text: append(text, byteNewLine), linewise: true}
+
+	switch operator {
+	case 'd':
+		es = es.removeRows(first, last)
+		es.cursor = cursor{row: first}
+		if first > len(es.buffer)-1 {
+			es.cursor.row = len(es.buffer) - 1
+		}
+		es.cursor.col = len(leadingWhitespace(es.buffer[es.cursor.row]))
+	case 'c':
+		indent := leadingWhitespace(es.buffer[first])
+		es.buffer = es.buffer.RemoveRange(cursor{row: first, col: len(indent)}, cursor{row: last, col: len(es.buffer[last])})
+		es.cursor = cursor{row: first, col: len(indent)}
+		es.vi.mode = viInsert
+	case 'y':
+		es.cursor = cursor{row: first, col: es.cursor.col}
+	}
+	es.mark = cursor{}
+	return es.viClampCursor()
+}
+
+// removeRows removes rows along with their newlines, leaving at least one row.
+func (es editorState) removeRows(first, last int) editorState {
+	switch {
+	case last < len(es.buffer)-1:
+		es.buffer = es.buffer.RemoveRange(cursor{row: first}, cursor{row: last + 1})
+	case first > 0:
+		es.buffer = es.buffer.RemoveRange(cursor{row: first - 1, col: len(es.buffer[first-1])}, cursor{row: last, col: len(es.buffer[last])})
+	default:
+		es.buffer = es.buffer.RemoveRange(cursor{}, cursor{row: last, col: len(es.buffer[last])})
+	}
+	return es
+}
+
+// viClampCursor keeps the cursor inside the buffer after an edit.
+func (es editorState) viClampCursor() editorState {
+	if es.cursor.row > len(es.buffer)-1 {
+		es.cursor.row = len(es.buffer) - 1
+	}
+	if es.cursor.col > len(es.buffer[es.cursor.row]) {
+		es.cursor.col = len(es.buffer[es.cursor.row])
+	}
+	return es
+}
+
+// viApplySelection applies an operator to the visual selection.
+func (es editorState) viApplySelection(operator byte) editorState {
+	from, to, _ := es.Selection()
+	mode := es.vi.mode
+	es.vi.mode = viNormal
+	if mode == viVisualLine {
+		return es.viApplyLines(operator, from.row, to.row)
+	}
+	return es.viApply(operator, viRange{from: from, to: to})
+}
+
+func (es editorState) viExitVisual() editorState {
+	if es.vi.mode == viVisual || es.vi.mode == viVisualLine {
+		es.vi.mode = viNormal
+	}
+	return es
+}
+
+// viVisualCommand handles the commands, other than motions and operators, in visual mode.
+func (es editorState) viVisualCommand(b byte) editorState {
+	switch b {
+	case 'x':
+		return es.viApplySelection('d')
+	case 'v':
+		if es.vi.mode == viVisual {
+			return es.viExitVisual()
+		}
+		es.vi.mode = viVisual
+	case 'V':
+		if es.vi.mode == viVisualLine {
+			return es.viExitVisual()
+		}
+		es.vi.mode = viVisualLine
+	case 'o':
+		es.mark, es.cursor = es.cursor, es.mark
+	}
+	return es
+}
+
+// viNormalCommand handles the commands, other than motions and operators, in normal mode.
+func (es editorState) viNormalCommand(b byte, count int) (editorState, error) {
+	switch b {
+	case 'i':
+		es.vi.mode = viInsert
+	case 'a':
+		es = es.MoveRight()
+		es.vi.mode = viInsert
+	case 'I':
+		es.cursor.col = len(leadingWhitespace(es.buffer[es.cursor.row]))
+		es.vi.mode = viInsert
+	case 'A':
+		es = es.MoveToEndOfLine()
+		es.vi.mode = viInsert
+	case 'o':
+		es = es.MoveToEndOfLine().Newline()
+		es.vi.mode = viInsert
+	case 'O':
+		row := es.cursor.row
+		indent := leadingWhitespace(es.buffer[row])
+		es.buffer = es.buffer.InsertRowAt(row).InsertAt(row, 0, indent)
+		es.cursor = cursor{row: row, col: len(indent)}
+		es.vi.mode = viInsert
+	case 'x':
+		return es.viRepeat(count, 'd', 'l')
+	case 'X':
+		return es.viRepeat(count, 'd', 'h')
+	case 's':
+		return es.viRepeat(count, 'c', 'l')
+	case 'D':
+		return es.viRepeat(count, 'd', '$')
+	case 'C':
+		return es.viRepeat(count, 'c', '$')
+	case 'S':
+		last := minInt(es.cursor.row+count-1, len(es.buffer)-1)
+		return es.viApplyLines('c', es.cursor.row, last), nil
+	case 'J':
+		for x := 0; x < count && es.cursor.row < len(es.buffer)-1; x++ {
+			es = es.MoveToEndOfLine()
+			next := es.buffer[es.cursor.row+1]
+			es.buffer = es.buffer.RemoveRange(es.cursor, cursor{row: es.cursor.row + 1, col: len(leadingWhitespace(next))})
+			if es.cursor.col > 0 && len(next) > 0 {
+				es.buffer = es.buffer.InsertAt(es.cursor.row, es.cursor.col, []byte{' '})
+			}
+		}
+	case 'p', 'P':
+		for x := 0; x < count; x++ {
+			es = es.viPaste(b == 'p')
+		}
+	case 'u':
+		for x := 0; x < count; x++ {
+			es = es.Undo()
+		}
+	case 'v':
+		es.mark = es.cursor
+		es.vi.mode = viVisual
+	case 'V':
+		es.mark = es.cursor
+		es.vi.mode = viVisualLine
+	case ':':
+		return es.Prompt(":", viExCommand), nil
+	}
+	return es, nil
+}
+
+// viRepeat applies an operator and motion as if they had been typed with a count.
+func (es editorState) viRepeat(count int, operator, motion byte) (editorState, error) {
+	return es.viMotion(motion, viState{operator: operator, count: count})
+}
+
+// viPaste pastes the clipboard after, or before, the cursor.
+func (es editorState) viPaste(after bool) editorState {
+	if len(es.clipboard.text) == 0 {
+		return es
+	}
+	if es.clipboard.linewise {
+		row := es.cursor.row
+		if after {
+			row++
+		}
+		text := es.clipboard.text[0 : len(es.clipboard.text)-1]
+		if row == len(es.buffer) { // after the last row the newline goes first
+			es.buffer, _ = es.buffer.InsertText(cursor{row: row - 1, col: len(es.buffer[row-1])}, append([]byte{byteNewLine}, text...))
+		} else {
+			es.buffer, _ = es.buffer.InsertText(cursor{row: row}, es.clipboard.text)
+		}
+		es.cursor = cursor{row: row, col: len(leadingWhitespace(es.buffer[row]))}
+		return es
+	}
+
+	at := es.cursor
+	if after && at.col < len(es.buffer[at.row]) {
+		at.col++
+	}
+	var end cursor
+	es.buffer, end = es.buffer.InsertText(at, es.clipboard.text)
+	es.cursor, _ = es.buffer.Prev(end)
+	return es
+}
+
+// viExCommand runs a command typed after `:`.
+func viExCommand(es editorState, command string) editorState {
+	command = strings.TrimSpace(command)
+	if line, err := strconv.Atoi(command); err == nil {
+		return es.GotoLine(line)
+	}
+	switch command {
+	case "w":
+		return es.Save()
+	case "q":
+		if es.Modified() {
+			es.message = "No write since last change (add ! to override)"
+			return es
+		}
+		es.quit = true
+	case "q!":
+		es.quit = true
+	case "wq", "x":
+		es = es.Save()
+		// saving may have asked something first, like about changes on disk, or failed.
+		es.quit = es.prompt == nil && !es.Modified()
+	default:
+		es.message = "not an editor command: " + command
+	}
+	return es
+}
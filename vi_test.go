@@ -0,0 +1,147 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func viStateFromString(contents string) editorState {
+	state := stateFromString(contents)
+	state.settings = settingsForPath("main.go")
+	state.vi.enabled = true
+	return state
+}
+
+func typeKeys(state editorState, keys string) editorState {
+	for x := 0; x < len(keys); x++ {
+		state, _ = processKey(key{b: keys[x]}, state)
+	}
+	return state
+}
+
+func TestViMotions(t *testing.T) {
+	assert := assert.New(t)
+
+	state := viStateFromString("foo.bar baz\n\tqux\nlast")
+	state = typeKeys(state, "w")
+	assert.Equal(cursor{row: 0, col: 3}, state.cursor)
+	state = typeKeys(state, "2w")
+	assert.Equal(cursor{row: 0, col: 8}, state.cursor)
+	state = typeKeys(state, "e")
+	assert.Equal(cursor{row: 0, col: 10}, state.cursor)
+	state = typeKeys(state, "j^")
+	assert.Equal(cursor{row: 1, col: 1}, state.cursor)
+	state = typeKeys(state, "G")
+	assert.Equal(cursor{row: 2, col: 0}, state.cursor)
+	state = typeKeys(state, "2gg$")
+	assert.Equal(cursor{row: 1, col: 4}, state.cursor)
+	state = typeKeys(state, "b0")
+	assert.Equal(cursor{row: 1, col: 0}, state.cursor)
+}
+
+func TestViOperators(t *testing.T) {
+	assert := assert.New(t)
+
+	state := viStateFromString("one two three\nfour\nfive")
+	state = typeKeys(state, "dw")
+	assert.Equal("two three", string(state.buffer[0]))
+	assert.Equal("one ", string(state.clipboard.text))
+
+	state = typeKeys(state, "cwTWO\x1b")
+	assert.Equal("TWO three", string(state.buffer[0]))
+	assert.Equal(cursor{row: 0, col: 2}, state.cursor)
+
+	state = typeKeys(state, "d$")
+	assert.Equal("TW", string(state.buffer[0]))
+
+	state = typeKeys(state, "j2dd")
+	assert.Len(state.buffer, 1)
+	assert.Equal("four\nfive\n", string(state.clipboard.text))
+
+	state = typeKeys(state, "p")
+	assert.Len(state.buffer, 3)
+	assert.Equal("four", string(state.buffer[1]))
+	assert.Equal("five", string(state.buffer[2]))
+
+	state = typeKeys(state, "yyP")
+	assert.Len(state.buffer, 4)
+	assert.Equal("four", string(state.buffer[1]))
+	assert.Equal("four", string(state.buffer[2]))
+}
+
+func TestViTextObjects(t *testing.T) {
+	assert := assert.New(t)
+
+	state := viStateFromString("call(a, \"b c\", d)")
+	state.cursor = cursor{row: 0, col: 9}
+	state = typeKeys(state, "ci\"x\x1b")
+	assert.Equal("call(a, \"x\", d)", string(state.buffer[0]))
+
+	state = typeKeys(state, "di(")
+	assert.Equal("call()", string(state.buffer[0]))
+
+	state = typeKeys(state, "0diw")
+	assert.Equal("()", string(state.buffer[0]))
+}
+
+func TestViVisual(t *testing.T) {
+	assert := assert.New(t)
+
+	state := viStateFromString("abcdef\nghi\njkl")
+	state = typeKeys(state, "lvlld")
+	assert.Equal("aef", string(state.buffer[0]))
+	assert.Equal(viNormal, state.vi.mode)
+
+	state = typeKeys(state, "jVjy")
+	assert.Equal("ghi\njkl\n", string(state.clipboard.text))
+	assert.True(state.clipboard.linewise)
+}
+
+func TestViUndoGroupsInsert(t *testing.T) {
+	assert := assert.New(t)
+
+	state := viStateFromString("abc")
+	state = typeKeys(state, "ixyz\x1b")
+	assert.Equal("xyzabc", string(state.buffer[0]))
+	state = typeKeys(state, "u")
+	assert.Equal("abc", string(state.buffer[0]))
+	assert.Equal(viNormal, state.vi.mode)
+}
+
+func TestViExQuit(t *testing.T) {
+	assert := assert.New(t)
+
+	state := typeKeys(viStateFromString("one\n"), "x:q\r")
+	assert.False(state.quit)
+	assert.Contains(state.message, "No write since last change")
+	assert.True(typeKeys(state, ":q!\r").quit)
+}
+
+func TestViExWriteQuitWaitsForSave(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	assert.Nil(os.WriteFile(path, []byte("one\n"), 0644))
+	state, err := loadFile(path)
+	assert.Nil(err)
+	state.vi.enabled = true
+	state = typeKeys(state, "x")
+	assert.Nil(os.WriteFile(path, []byte("changed elsewhere\n"), 0644))
+
+	state = typeKeys(state, ":wq\r")
+	assert.NotNil(state.prompt)
+	assert.False(state.quit)
+}
+
+func TestViCountIsCapped(t *testing.T) {
+	assert := assert.New(t)
+
+	state := typeKeys(viStateFromString("x\n"), "99999999999999999999")
+	assert.Equal(maxCount, state.vi.count)
+	// found by the fuzzer, which hung repeating X a hundred billion times.
+	state = typeKeys(viStateFromString("0"), "11111111111111U111111111111XX")
+	assert.Equal("0", string(state.buffer[0]))
+}
@@ -0,0 +1,38 @@
+package main
+
+// viewing is text shown in place of the buffer, like a diff, until the user is done with it.
+type viewing struct {
+	// back returns to editing when the user presses q.
+	back func() editorState
+}
+
+// ShowText shows text in place of the buffer until the user presses q, then calls back with the state from before.
+func (es editorState) ShowText(text []byte, back func(editorState) editorState) editorState {
+	previous := es
+	view := editorState{
+		buffer:   bufferFromBytes(text),
+		settings: es.settings,
+		width:    es.width,
+		height:   es.height,
+		message:  "q to return",
+		viewing: &viewing{
+			back: func() editorState {
+				return back(previous)
+			},
+		},
+	}
+	return view
+}
+
+// processViewKey handles keys while viewing text; the text can be moved around in but not changed.
+func processViewKey(k key, state editorState) (editorState, error) {
+	if k == (key{b: 'q'}) || k == (key{b: ANSI.bel}) {
+		return state.viewing.back(), nil
+	}
+	moved, err := processEmacsKey(k, state)
+	if err != nil || !moved.buffer.Same(state.buffer) {
+		return state, err
+	}
+	moved.prompt = nil
+	return moved, nil
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// cellStyle is the set of text attributes a cell was drawn with.
+type cellStyle byte
+
+const (
+	styleReverse cellStyle = 1 << iota
+	styleWarning
+)
+
+// cell is a single character on a virtual terminal's screen.
+type cell struct {
+	c     byte
+	style cellStyle
+}
+
+// virtualTerminal interprets the ansi sequences the editor writes into a grid of cells,
+// so what's drawn can be checked without a real terminal.
+type virtualTerminal struct {
+	width, height int
+	cells         [][]cell
+	// row and col are the zero based cursor position.
+	row, col int
+	style    cellStyle
+	// pending is an escape sequence cut off at the end of a write.
+	pending []byte
+}
+
+// newVirtualTerminal returns a blank virtual terminal of a size.
+func newVirtualTerminal(width, height int) *virtualTerminal {
+	vt := &virtualTerminal{width: width, height: height}
+	vt.clear()
+	return vt
+}
+
+func (vt *virtualTerminal) clear() {
+	vt.cells = make([][]cell, vt.height)
+	for y := range vt.cells {
+		vt.cells[y] = vt.blankRow()
+	}
+}
+
+func (vt *virtualTerminal) blankRow() []cell {
+	row := make([]cell, vt.width)
+	for x := range row {
+		row[x] = cell{c: ' '}
+	}
+	return row
+}
+
+// Write interprets text and escape sequences.
+func (vt *virtualTerminal) Write(p []byte) (int, error) {
+	data := append(vt.pending, p...)
+	vt.pending = nil
+	for x := 0; x < len(data); x++ {
+		b := data[x]
+		switch {
+		case b == ANSI.esc:
+			length, ok := vt.escape(data[x:])
+			if !ok {
+				vt.pending = append([]byte{}, data[x:]...)
+				return len(p), nil
+			}
+			x += length - 1
+		case b == ANSI.cr:
+			vt.col = 0
+		case b == ANSI.lf:
+			vt.lineFeed()
+		case b >= ' ':
+			vt.put(b)
+		}
+	}
+	return len(p), nil
+}
+
+// put draws a character at the cursor, wrapping at the right edge like a real terminal.
+func (vt *virtualTerminal) put(b byte) {
+	if vt.col >= vt.width {
+		vt.col = 0
+		vt.lineFeed()
+	}
+	if vt.row < vt.height {
+		vt.cells[vt.row][vt.col] = cell{c: b, style: vt.style}
+	}
+	vt.col++
+}
+
+// lineFeed moves the cursor down a row, scrolling the screen up at the bottom.
+func (vt *virtualTerminal) lineFeed() {
+	if vt.row < vt.height-1 {
+		vt.row++
+		return
+	}
+	vt.cells = append(vt.cells[1:], vt.blankRow())
+}
+
+// escape interprets the escape sequence at the start of data, returning its length,
+// or false if the sequence is incomplete.
+func (vt *virtualTerminal) escape(data []byte) (int, bool) {
+	if len(data) < 2 {
+		return 0, false
+	}
+	if data[1] != '[' {
+		return 2, true
+	}
+	end := 2
+	for end < len(data) && (data[end] < '@' || data[end] > '~') {
+		end++
+	}
+	if end == len(data) {
+		return 0, false
+	}
+
+	params := string(data[2:end])
+	if strings.HasPrefix(params, "?") { // private modes like bracketed paste
+		return end + 1, true
+	}
+	args := strings.Split(params, ";")
+	arg := func(index, otherwise int) int {
+		if index >= len(args) {
+			return otherwise
+		}
+		value, err := strconv.Atoi(args[index])
+		if err != nil || value == 0 {
+			return otherwise
+		}
+		return value
+	}
+
+	switch data[end] {
+	case 'H':
+		vt.row = minInt(arg(0, 1), vt.height) - 1
+		vt.col = minInt(arg(1, 1), vt.width) - 1
+	case 'J':
+		if arg(0, 0) == 2 {
+			vt.clear()
+		}
+	case 'K':
+		if arg(0, 0) == 2 && vt.row < vt.height {
+			vt.cells[vt.row] = vt.blankRow()
+		}
+	case 'm':
+		for index := range args {
+			switch arg(index, 0) {
+			case 0:
+				vt.style = 0
+			case 7:
+				vt.style |= styleReverse
+			case 41:
+				vt.style |= styleWarning
+			}
+		}
+	}
+	return end + 1, true
+}
+
+// Text returns the characters on a row of the screen, without trailing spaces.
+func (vt *virtualTerminal) Text(row int) string {
+	text := make([]byte, vt.width)
+	for x, c := range vt.cells[row] {
+		text[x] = c.c
+	}
+	return strings.TrimRight(string(text), " ")
+}
+
+// String returns the screen as text, with the styled cells marked under each row
+// (`r` for reverse, `w` for warning) and then the cursor position.
+func (vt *virtualTerminal) String() string {
+	var output strings.Builder
+	for y := range vt.cells {
+		fmt.Fprintf(&output, "|%s\n", vt.Text(y))
+
+		marks := make([]byte, vt.width)
+		var styled bool
+		for x, c := range vt.cells[y] {
+			switch {
+			case c.style&styleWarning != 0:
+				marks[x] = 'w'
+			case c.style&styleReverse != 0:
+				marks[x] = 'r'
+			default:
+				marks[x] = ' '
+			}
+			styled = styled || c.style != 0
+		}
+		if styled {
+			fmt.Fprintf(&output, "^%s\n", strings.TrimRight(string(marks), " "))
+		}
+	}
+	fmt.Fprintf(&output, "cursor %d:%d\n", vt.row+1, vt.col+1)
+	return output.String()
+}
@@ -0,0 +1,49 @@
+package main
+
+// TrimTrailingWhitespace removes spaces and tabs from the ends of rows, or only from the rows
+// added or changed since the file was saved; if there are too many changes to tell which rows
+// they are, every row near them is trimmed.
+func (es editorState) TrimTrailingWhitespace(modifiedOnly bool) editorState {
+	rows := make([]bool, len(es.buffer))
+	if modifiedOnly {
+		for _, op := range diffOps(es.saved, es.buffer) {
+			if op.kind == '+' {
+				rows[op.b] = true
+			}
+		}
+	}
+	// the rows are trimmed in one copy of the buffer, rather than a copy per row.
+	var output buffer
+	for y, row := range es.buffer {
+		if modifiedOnly && !rows[y] {
+			continue
+		}
+		if trimmed := trimTrailingWhitespace(row); len(trimmed) < len(row) {
+			if output == nil {
+				output = append(buffer{}, es.buffer...)
+			}
+			// capped like TrimRowAt, so appending to the row copies it.
+			output[y] = trimmed[0:len(trimmed):len(trimmed)]
+		}
+	}
+	if output != nil {
+		es.buffer = output
+	}
+	es.cursor = es.buffer.Clamp(es.cursor)
+	return es
+}
+
+// EnsureFinalNewline makes the buffer end with exactly one newline, unless it's empty.
+func (es editorState) EnsureFinalNewline() editorState {
+	last := len(es.buffer) - 1
+	if len(es.buffer[last]) > 0 {
+		es.buffer = es.buffer.InsertRowAt(last + 1)
+		return es
+	}
+	for last > 1 && len(es.buffer[last-1]) == 0 {
+		es.buffer = es.buffer.RemoveRowAt(last)
+		last--
+	}
+	es.cursor = es.buffer.Clamp(es.cursor)
+	return es
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	assert "github.com/blendlabs/go-assert"
+)
+
+func TestSaveCleansWhitespace(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "notes.txt")
+	assert.Nil(os.WriteFile(path, []byte("one  \ntwo\t\nthree"), 0644))
+	state, err := loadFile(path)
+	assert.Nil(err)
+	state.settings.trimTrailingWhitespace = true
+	state.settings.finalNewline = true
+
+	state = typeNotation(state, `C-x C-s`)
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal("one\ntwo\nthree\n", string(written))
+	assert.False(state.Modified())
+
+	// the clean up is undone like any other change.
+	assert.Equal("one  \ntwo\t\nthree", string(typeNotation(state, `C-_`).buffer.Bytes()))
+}
+
+func TestTrimModifiedLinesOnly(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("one  \ntwo  \nthree  \n")
+	state = typeNotation(state, `<Down> C-e "2 "`)
+	state = state.TrimTrailingWhitespace(true)
+	assert.Equal("one  \ntwo  2\nthree  \n", string(state.buffer.Bytes()))
+	assert.Equal(cursor{row: 1, col: 6}, state.cursor)
+}
+
+func TestEnsureFinalNewline(t *testing.T) {
+	assert := assert.New(t)
+
+	assert.Equal("one\n", string(stateFromString("one").EnsureFinalNewline().buffer.Bytes()))
+	assert.Equal("one\n", string(stateFromString("one\n\n\n").EnsureFinalNewline().buffer.Bytes()))
+	assert.Equal("one\n", string(stateFromString("one\n").EnsureFinalNewline().buffer.Bytes()))
+	assert.Equal("", string(stateFromString("").EnsureFinalNewline().buffer.Bytes()))
+}
+
+func TestRenderTrailingWhitespace(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("one  \ntwo \n").Resize(10, 3)
+	state.settings.showTrailingWhitespace = true
+	state = typeNotation(state, `<Down> C-e`)
+
+	vt := renderScreen(state)
+	assert.Equal(styleWarning, vt.cells[0][3].style)
+	assert.Equal(styleWarning, vt.cells[0][4].style)
+	assert.Equal(cellStyle(0), vt.cells[0][2].style)
+	assert.Equal(cellStyle(0), vt.cells[1][3].style) // the cursor is at the end
+}
+
+func TestTrimModifiedLinesOnlyInLongFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	text := strings.Repeat("row  \n", 60000)
+	state := stateFromString(text)
+	state = typeNotation(state, `C-e "1" M-> C-p C-e "2"`)
+	state = state.TrimTrailingWhitespace(true)
+	assert.Equal("row  1", string(state.buffer[0]))
+	assert.Equal("row  ", string(state.buffer[1]))
+	assert.Equal("row  ", string(state.buffer[59998]))
+	assert.Equal("row  2", string(state.buffer[59999]))
+
+	// past what the diff looks for, the changed rows are all trimmed.
+	state = stateFromString(text)
+	state.buffer = bufferFromBytes([]byte(strings.Repeat("other  \n", 60000)))
+	state = state.TrimTrailingWhitespace(true)
+	assert.Equal(strings.Repeat("other\n", 60000), string(state.buffer.Bytes()))
+}
+
+func TestSaveCleanupsSkipBinaryFiles(t *testing.T) {
+	assert := assert.New(t)
+
+	path := filepath.Join(t.TempDir(), "data.bin")
+	data := "\x00\x01\x02 \n\t\t\n\x00\xff"
+	assert.Nil(os.WriteFile(path, []byte(data), 0644))
+	state, err := loadFile(path)
+	assert.Nil(err)
+	assert.True(state.hex.enabled)
+	state.settings.trimTrailingWhitespace = true
+	state.settings.finalNewline = true
+
+	state = typeNotation(state, `"00" C-x C-s`)
+	written, err := os.ReadFile(path)
+	assert.Nil(err)
+	assert.Equal(data, string(written))
+}
+
+func TestRenderTrailingWhitespaceOnMatchedBracketRow(t *testing.T) {
+	assert := assert.New(t)
+
+	state := stateFromString("{}  \n").Resize(10, 3)
+	state.settings.showTrailingWhitespace = true
+
+	vt := renderScreen(state)
+	assert.Equal(styleReverse, vt.cells[0][0].style)
+	assert.Equal(styleReverse, vt.cells[0][1].style)
+	assert.Equal(styleWarning, vt.cells[0][2].style)
+	assert.Equal(styleWarning, vt.cells[0][3].style)
+}